@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/term"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// manifest describes the desired tunnels in a YAML or JSON file. Tunnel
+// fields use the same names as the create api.
+type manifest struct {
+	Tunnels []api.CreateRequest `json:"tunnels"`
+}
+
+func ApplyCmd() *cobra.Command {
+	var file, outputFormat string
+	var dryRun, prune bool
+
+	applyCmd := &cobra.Command{
+		Use:           "apply",
+		Short:         "Create, update and delete tunnels to match a manifest",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" {
+				return fmt.Errorf("manifest file is required")
+			}
+			return Apply(file, dryRun, prune, outputFormat)
+		},
+	}
+
+	applyCmd.Flags().StringVarP(&file, "file", "f", "", "manifest file in YAML or JSON, - for stdin (required)")
+	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "show the changes without applying them")
+	applyCmd.Flags().BoolVar(&prune, "prune", false, "delete tunnels that are not in the manifest")
+	applyCmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format (table, json, yaml)")
+
+	return applyCmd
+}
+
+func Apply(file string, dryRun, prune bool, outputFormat string) error {
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading manifest: %s", err)
+	}
+
+	m, err := parseManifest(b)
+	if err != nil {
+		return fmt.Errorf("error parsing manifest: %s", err)
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx := context.Background()
+
+	req := &api.ApplyRequest{
+		Tunnels: m.Tunnels,
+		DryRun:  dryRun,
+		Prune:   prune,
+	}
+	result, err := client.Apply(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error applying: %s", err)
+	}
+
+	switch outputFormat {
+	case "table":
+		printApplyResult(result)
+	case "json":
+		b, err := term.PrettyJSON(result)
+		if err != nil {
+			return fmt.Errorf("error marshaling result to json: %s", err)
+		}
+		fmt.Println(string(b))
+	case "yaml":
+		b, err := term.PrettyYAML(result)
+		if err != nil {
+			return fmt.Errorf("error marshaling result to yaml: %s", err)
+		}
+		fmt.Print(string(b))
+	default:
+		return fmt.Errorf("invalid output format: %s", outputFormat)
+	}
+
+	return nil
+}
+
+// parseManifest decodes a YAML or JSON manifest. YAML is converted to
+// JSON first so both formats share the api's json field names.
+func parseManifest(b []byte) (*manifest, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+
+	// match the defaults of tfarm create
+	for i := range m.Tunnels {
+		t := &m.Tunnels[i]
+		if t.Type == "" {
+			t.Type = "http"
+		}
+		if t.LocalIP == "" && t.Plugin == nil {
+			t.LocalIP = "127.0.0.1"
+		}
+	}
+
+	return m, nil
+}
+
+func printApplyResult(result *api.ApplyResponse) {
+	suffix := ""
+	if result.DryRun {
+		suffix = " (dry run)"
+	}
+	for _, name := range result.Created {
+		fmt.Printf("%s created%s\n", name, suffix)
+	}
+	for _, name := range result.Updated {
+		fmt.Printf("%s updated%s\n", name, suffix)
+	}
+	for _, name := range result.Deleted {
+		fmt.Printf("%s deleted%s\n", name, suffix)
+	}
+	for _, name := range result.Unchanged {
+		fmt.Printf("%s unchanged\n", name)
+	}
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -105,16 +106,14 @@ func Configure(clientID, clientSecret, clientCACert, clientTLSCert, clientTLSKey
 		return fmt.Errorf("error creating client: %s", err)
 	}
 
-	status, err := client.Configure(credentials)
+	ctx := context.Background()
+
+	status, err := client.Configure(ctx, credentials)
 	if err != nil {
 		return fmt.Errorf("error creating: %s", err)
 	}
 
-	if status.Success {
-		fmt.Println(status.Message)
-	} else {
-		fmt.Println(status.Error)
-	}
+	fmt.Println(status.Message)
 
 	return nil
 }
@@ -1,17 +1,20 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
 	"github.com/spf13/cobra"
 )
 
 func CreateCmd() *cobra.Command {
-	var tunnelType string
-	var localIP string
-	var localPort int
-	var remotePort int
+	req := &api.CreateRequest{}
+	plugin := &api.Plugin{}
+	var ttl time.Duration
+	var wait bool
+	var waitTimeout time.Duration
 
 	createCmd := &cobra.Command{
 		Use:           "create [NAME]",
@@ -22,50 +25,93 @@ func CreateCmd() *cobra.Command {
 			if len(args) != 1 {
 				return fmt.Errorf("name is required")
 			}
-			return Create(args[0], tunnelType, localIP, localPort, remotePort)
+			req.Name = args[0]
+			if plugin.Name != "" {
+				if cmd.Flags().Changed("local-ip") {
+					return fmt.Errorf("local ip is not valid with a plugin")
+				}
+				req.LocalIP = ""
+				req.Plugin = plugin
+			} else if len(plugin.Params) > 0 {
+				return fmt.Errorf("plugin params require a plugin")
+			}
+			if ttl > 0 {
+				req.TTL = ttl.String()
+			}
+			if !wait {
+				waitTimeout = 0
+			}
+			return Create(req, waitTimeout)
 		},
 	}
 
-	createCmd.Flags().StringVarP(&tunnelType, "type", "t", "http", "tunnel type (http, tcp)")
-	createCmd.Flags().StringVarP(&localIP, "local-ip", "l", "127.0.0.1", "local ip address")
-	createCmd.Flags().IntVarP(&localPort, "local-port", "p", 0, "local port (required)")
-	createCmd.Flags().IntVarP(&remotePort, "remote-port", "r", 0, "remote port (required for tcp)")
+	createCmd.Flags().StringVarP(&req.Type, "type", "t", "http", "tunnel type (http, https, tcp, udp, stcp, xtcp, sudp)")
+	createCmd.Flags().StringVarP(&req.LocalIP, "local-ip", "l", "127.0.0.1", "local ip address")
+	createCmd.Flags().IntVarP(&req.LocalPort, "local-port", "p", 0, "local port (required without a plugin)")
+	createCmd.Flags().IntVarP(&req.RemotePort, "remote-port", "r", 0, "remote port (required for tcp and udp)")
+	createCmd.Flags().StringVar(&req.SecretKey, "sk", "", "secret key visitors must present (required for stcp, xtcp and sudp)")
+	createCmd.Flags().StringSliceVar(&req.AllowUsers, "allow-users", nil, "users allowed to visit the tunnel, * for all (stcp, xtcp and sudp)")
+	createCmd.Flags().StringSliceVar(&req.CustomDomains, "custom-domains", nil, "custom domains to route to the tunnel (http and https)")
+	createCmd.Flags().StringSliceVar(&req.Locations, "locations", nil, "path prefixes to route to the tunnel (http)")
+	createCmd.Flags().StringVar(&req.HostHeaderRewrite, "host-header-rewrite", "", "rewrite the host header of proxied requests (http)")
+	createCmd.Flags().StringVar(&req.HTTPUser, "http-user", "", "basic auth user (http)")
+	createCmd.Flags().StringVar(&req.HTTPPwd, "http-pwd", "", "basic auth password (http)")
+	createCmd.Flags().StringToStringVar(&req.Headers, "header", nil, "header to set on proxied requests as NAME=VALUE, can be repeated (http)")
+
+	createCmd.Flags().StringVar(&req.BandwidthLimit, "bandwidth-limit", "", "bandwidth limit such as 512KB or 1MB")
+	createCmd.Flags().BoolVar(&req.UseEncryption, "use-encryption", false, "encrypt traffic between frpc and frps")
+	createCmd.Flags().BoolVar(&req.UseCompression, "use-compression", false, "compress traffic between frpc and frps")
+	createCmd.Flags().StringVar(&req.HealthCheckType, "health-check-type", "", "health check the local service (tcp, http)")
+	createCmd.Flags().StringVar(&req.HealthCheckURL, "health-check-url", "", "path to request for http health checks")
+	createCmd.Flags().IntVar(&req.HealthCheckInterval, "health-check-interval", 0, "seconds between health checks (default 10)")
+	createCmd.Flags().StringVar(&req.Group, "group", "", "load balancing group shared by tunnels with the same group key (tcp and http)")
+	createCmd.Flags().StringVar(&req.GroupKey, "group-key", "", "load balancing group key")
+	createCmd.Flags().StringToStringVar(&req.Labels, "label", nil, "label as KEY=VALUE, can be repeated")
+	createCmd.Flags().StringVar(&req.Description, "description", "", "description of the tunnel")
+	createCmd.Flags().DurationVar(&ttl, "ttl", 0, "delete the tunnel after this duration, e.g. 2h")
+	createCmd.Flags().StringVar(&plugin.Name, "plugin", "", "client plugin to serve the tunnel instead of a local port (static_file, unix_domain_socket, http_proxy, socks5, https2http, https2https, http2https)")
+	createCmd.Flags().StringToStringVar(&plugin.Params, "plugin-param", nil, "plugin param as NAME=VALUE without the plugin_ prefix, can be repeated")
+	createCmd.Flags().BoolVar(&wait, "wait", false, "wait for the tunnel to be running")
+	createCmd.Flags().DurationVar(&waitTimeout, "wait-timeout", api.DefaultWaitTimeout, "how long to wait for the tunnel to be running")
 
 	return createCmd
 }
 
-func Create(name string, tunnelType string, localIP string, localPort int, remotePort int) error {
-	if localPort == 0 {
+// Create creates the tunnel. If waitTimeout is not zero, it then waits up
+// to waitTimeout for the tunnel to be running.
+func Create(req *api.CreateRequest, waitTimeout time.Duration) error {
+	if req.Plugin == nil && req.LocalPort == 0 {
 		return fmt.Errorf("local port is required")
 	}
 
-	isRemotePortRequired := tunnelType == "tcp" || tunnelType == "udp"
+	isRemotePortRequired := req.Type == "tcp" || req.Type == "udp"
 
-	if isRemotePortRequired && remotePort == 0 {
+	if isRemotePortRequired && req.RemotePort == 0 {
 		return fmt.Errorf("remote port is required for tcp and udp tunnels")
 	}
 
+	isSecretKeyRequired := req.Type == "stcp" || req.Type == "xtcp" || req.Type == "sudp"
+
+	if isSecretKeyRequired && req.SecretKey == "" {
+		return fmt.Errorf("secret key is required for stcp, xtcp and sudp tunnels")
+	}
+
 	client, err := getClient()
 	if err != nil {
 		return fmt.Errorf("error creating client: %s", err)
 	}
 
-	req := &api.CreateRequest{
-		Name:       name,
-		Type:       tunnelType,
-		LocalIP:    localIP,
-		LocalPort:  localPort,
-		RemotePort: remotePort,
-	}
-	status, err := client.Create(req)
+	ctx := context.Background()
+
+	status, err := client.Create(ctx, req)
 	if err != nil {
 		return fmt.Errorf("error creating: %s", err)
 	}
 
-	if status.Success {
-		fmt.Println(status.Message)
-	} else {
-		fmt.Println(status.Error)
+	fmt.Println(status.Message)
+
+	if waitTimeout > 0 {
+		return Wait(&api.WaitRequest{Name: req.Name, Timeout: waitTimeout})
 	}
 
 	return nil
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
@@ -30,19 +31,17 @@ func Delete(name string) error {
 		return fmt.Errorf("error creating client: %s", err)
 	}
 
+	ctx := context.Background()
+
 	req := &api.DeleteRequest{
 		Name: name,
 	}
-	status, err := client.Delete(req)
+	status, err := client.Delete(ctx, req)
 	if err != nil {
 		return fmt.Errorf("error deleting: %s", err)
 	}
 
-	if status.Success {
-		fmt.Println(status.Message)
-	} else {
-		fmt.Println(status.Error)
-	}
+	fmt.Println(status.Message)
 
 	return nil
 }
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func EventsCmd() *cobra.Command {
+	var follow bool
+	var outputFormat string
+
+	eventsCmd := &cobra.Command{
+		Use:           "events",
+		Short:         "Show tunnel and frpc events",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Events(follow, outputFormat)
+		},
+	}
+
+	eventsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "stream new events until interrupted")
+	eventsCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format (text, json)")
+
+	return eventsCmd
+}
+
+func Events(follow bool, outputFormat string) error {
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("invalid output format: %s", outputFormat)
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	if !follow {
+		res, err := client.Events(context.Background())
+		if err != nil {
+			return fmt.Errorf("error getting events: %s", err)
+		}
+		for _, e := range res.Events {
+			if err := printEvent(e, outputFormat); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = client.FollowEvents(ctx, func(e api.Event) error {
+		return printEvent(e, outputFormat)
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("error following events: %s", err)
+	}
+
+	return nil
+}
+
+// printEvent prints the event on one line, either as text or as JSON so
+// the output can be piped to tools like jq.
+func printEvent(e api.Event, outputFormat string) error {
+	if outputFormat == "json" {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("error marshaling event to json: %s", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	fields := []string{e.Time.Local().Format(time.RFC3339), e.Type}
+	for _, field := range []string{e.Tunnel, e.Status, e.Message} {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	fmt.Println(strings.Join(fields, " "))
+	return nil
+}
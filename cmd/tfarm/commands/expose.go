@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func ExposeCmd() *cobra.Command {
+	req := &api.CreateRequest{}
+	var lease, timeout time.Duration
+
+	exposeCmd := &cobra.Command{
+		Use:           "expose [PORT]",
+		Short:         "Expose a local port until interrupted",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("port is required")
+			}
+			port, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid port: %s", args[0])
+			}
+			req.LocalPort = port
+			if req.Name == "" {
+				name, err := generateTunnelName("expose")
+				if err != nil {
+					return err
+				}
+				req.Name = name
+			}
+			req.Lease = lease.String()
+			return Expose(req, lease, timeout)
+		},
+	}
+
+	exposeCmd.Flags().StringVarP(&req.Name, "name", "n", "", "tunnel name (generated by default)")
+	exposeCmd.Flags().StringVarP(&req.Type, "type", "t", "http", "tunnel type (http, https, tcp, udp)")
+	exposeCmd.Flags().StringVarP(&req.LocalIP, "local-ip", "l", "127.0.0.1", "local ip address")
+	exposeCmd.Flags().IntVarP(&req.RemotePort, "remote-port", "r", 0, "remote port (required for tcp and udp)")
+	exposeCmd.Flags().DurationVar(&lease, "lease", 30*time.Second, "how long the tunnel outlives this command if it exits without cleaning up")
+	exposeCmd.Flags().DurationVar(&timeout, "timeout", time.Minute, "how long to wait for the tunnel to be running")
+
+	return exposeCmd
+}
+
+// generateTunnelName returns the prefix followed by a random suffix.
+func generateTunnelName(prefix string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating tunnel name: %s", err)
+	}
+	return prefix + "-" + hex.EncodeToString(b), nil
+}
+
+// Expose creates a leased tunnel, keeps the lease renewed and prints
+// status changes until interrupted, then deletes the tunnel. If this
+// command dies without deleting it, tfarmd deletes the tunnel once the
+// lease runs out.
+func Expose(req *api.CreateRequest, lease, timeout time.Duration) error {
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if _, err := client.Create(ctx, req); err != nil {
+		return fmt.Errorf("error creating: %s", err)
+	}
+	fmt.Printf("tunnel %s created\n", req.Name)
+
+	defer func() {
+		// the signal context is done by now, so use a fresh one
+		deleteCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, err := client.Delete(deleteCtx, &api.DeleteRequest{Name: req.Name}); err != nil {
+			fmt.Fprintf(os.Stderr, "error deleting tunnel %s: %s\n", req.Name, err)
+			return
+		}
+		fmt.Printf("tunnel %s deleted\n", req.Name)
+	}()
+
+	renew := time.NewTicker(lease / 3)
+	defer renew.Stop()
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+	deadline := time.After(timeout)
+
+	var last api.Tunnel
+	running := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-deadline:
+			if !running {
+				return fmt.Errorf("tunnel %s not running after %s: %s", req.Name, timeout, last.Error)
+			}
+		case <-renew.C:
+			if _, err := client.Renew(ctx, &api.RenewRequest{Name: req.Name}); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "error renewing lease: %s\n", err)
+			}
+		case <-poll.C:
+			tunnel, err := client.Get(ctx, &api.GetRequest{Name: req.Name})
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "error getting tunnel status: %s\n", err)
+				}
+				continue
+			}
+
+			if !running && tunnel.Status == "running" {
+				running = true
+				fmt.Printf("forwarding %s -> %s:%d\n", tunnel.RemoteURL, req.LocalIP, req.LocalPort)
+				fmt.Println("press ctrl-c to stop")
+			}
+			if tunnel.Status != last.Status || tunnel.Error != last.Error {
+				if tunnel.Error != "" {
+					fmt.Printf("status: %s (%s)\n", tunnel.Status, tunnel.Error)
+				} else {
+					fmt.Printf("status: %s\n", tunnel.Status)
+				}
+			}
+			last = *tunnel
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/term"
+	"github.com/spf13/cobra"
+)
+
+func GetCmd() *cobra.Command {
+	var outputFormat string
+
+	getCmd := &cobra.Command{
+		Use:           "get [NAME]",
+		Short:         "Get a tunnel",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("name is required")
+			}
+			return Get(args[0], outputFormat)
+		},
+	}
+
+	getCmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format (table, json, yaml)")
+
+	return getCmd
+}
+
+func Get(name string, outputFormat string) error {
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx := context.Background()
+
+	req := &api.GetRequest{
+		Name: name,
+	}
+	tunnel, err := client.Get(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error getting tunnel: %s", err)
+	}
+
+	switch outputFormat {
+	case "table":
+		printTunnelsTable([]api.Tunnel{*tunnel})
+	case "json":
+		b, err := term.PrettyJSON(tunnel)
+		if err != nil {
+			return fmt.Errorf("error marshaling tunnel to json: %s", err)
+		}
+		fmt.Println(string(b))
+	case "yaml":
+		b, err := term.PrettyYAML(tunnel)
+		if err != nil {
+			return fmt.Errorf("error marshaling tunnel to yaml: %s", err)
+		}
+		fmt.Print(string(b))
+	default:
+		return fmt.Errorf("invalid output format: %s", outputFormat)
+	}
+
+	return nil
+}
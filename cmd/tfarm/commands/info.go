@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/cbodonnell/tfarm/pkg/term"
@@ -31,7 +32,9 @@ func Info(outputFormat string) error {
 		return fmt.Errorf("error creating client: %s", err)
 	}
 
-	info := client.Info()
+	ctx := context.Background()
+
+	info := client.Info(ctx)
 
 	switch outputFormat {
 	// TODO: make this yaml so it can be more dynamic
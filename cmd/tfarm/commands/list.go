@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/term"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+func ListCmd() *cobra.Command {
+	var outputFormat string
+	var selector string
+
+	listCmd := &cobra.Command{
+		Use:           "list",
+		Short:         "List all tunnels",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return List(selector, outputFormat)
+		},
+	}
+
+	listCmd.Flags().StringVarP(&selector, "selector", "l", "", "only list tunnels with matching labels, e.g. env=preview,team!=infra")
+	listCmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format (table, json, yaml)")
+
+	return listCmd
+}
+
+func List(selector, outputFormat string) error {
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx := context.Background()
+
+	req := &api.ListRequest{Selector: selector}
+	res, err := client.ListTunnels(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error listing tunnels: %s", err)
+	}
+
+	switch outputFormat {
+	case "table":
+		printTunnelsTable(res.Tunnels)
+	case "json":
+		b, err := term.PrettyJSON(res.Tunnels)
+		if err != nil {
+			return fmt.Errorf("error marshaling tunnels to json: %s", err)
+		}
+		fmt.Println(string(b))
+	case "yaml":
+		b, err := term.PrettyYAML(res.Tunnels)
+		if err != nil {
+			return fmt.Errorf("error marshaling tunnels to yaml: %s", err)
+		}
+		fmt.Print(string(b))
+	default:
+		return fmt.Errorf("invalid output format: %s", outputFormat)
+	}
+
+	return nil
+}
+
+func printTunnelsTable(tunnels []api.Tunnel) {
+	tbl := table.New("Name", "Type", "Status", "Local", "Remote", "Error").WithWriter(os.Stdout)
+	for _, t := range tunnels {
+		if t.Role == "visitor" {
+			local := fmt.Sprintf("%s:%d", t.BindAddr, t.BindPort)
+			tbl.AddRow(t.Name, t.Type+" visitor", t.Status, local, t.ServerName, t.Error)
+			continue
+		}
+		local := fmt.Sprintf("%s:%d", t.LocalIP, t.LocalPort)
+		if t.Plugin != nil {
+			local = "plugin " + t.Plugin.Name
+		}
+		tbl.AddRow(t.Name, t.Type, t.Status, local, t.RemoteURL, t.Error)
+	}
+	tbl.Print()
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
@@ -27,17 +28,15 @@ func Restart() error {
 		return fmt.Errorf("error creating client: %s", err)
 	}
 
+	ctx := context.Background()
+
 	req := &api.APIRequest{}
-	status, err := client.Restart(req)
+	status, err := client.Restart(ctx, req)
 	if err != nil {
 		return fmt.Errorf("error restarting: %s", err)
 	}
 
-	if status.Success {
-		fmt.Println(status.Message)
-	} else {
-		fmt.Println(status.Error)
-	}
+	fmt.Println(status.Message)
 
 	return nil
 }
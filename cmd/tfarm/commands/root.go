@@ -25,14 +25,24 @@ func RootCmd() *cobra.Command {
 		},
 	}
 
+	rootCmd.AddCommand(ApplyCmd())
 	rootCmd.AddCommand(ConfigureCmd())
 	rootCmd.AddCommand(CreateCmd())
 	rootCmd.AddCommand(DeleteCmd())
+	rootCmd.AddCommand(EventsCmd())
+	rootCmd.AddCommand(ExposeCmd())
+	rootCmd.AddCommand(GetCmd())
 	rootCmd.AddCommand(InfoCmd())
+	rootCmd.AddCommand(ListCmd())
 	rootCmd.AddCommand(ReloadCmd())
 	rootCmd.AddCommand(RestartCmd())
+	rootCmd.AddCommand(ShareCmd())
+	rootCmd.AddCommand(StatsCmd())
 	rootCmd.AddCommand(StatusCmd())
+	rootCmd.AddCommand(UpdateCmd())
 	rootCmd.AddCommand(VerifyCmd())
+	rootCmd.AddCommand(VisitCmd())
+	rootCmd.AddCommand(WaitCmd())
 
 	// add the server subcommand
 	rootCmd.AddCommand(server.RootCmd())
@@ -21,6 +21,7 @@ func RootCmd() *cobra.Command {
 	rootCmd.AddCommand(StartCmd())
 	rootCmd.AddCommand(ConfigureCmd())
 	rootCmd.AddCommand(CertsCmd())
+	rootCmd.AddCommand(StatusCmd())
 
 	return rootCmd
 }
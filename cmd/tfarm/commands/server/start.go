@@ -1,16 +1,26 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
 	"github.com/cbodonnell/tfarm/pkg/certs"
+	"github.com/cbodonnell/tfarm/pkg/events"
 	"github.com/cbodonnell/tfarm/pkg/frpc"
 	"github.com/cbodonnell/tfarm/pkg/handlers"
+	"github.com/cbodonnell/tfarm/pkg/logging"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/cbodonnell/tfarm/pkg/stats"
 	"github.com/cbodonnell/tfarm/pkg/version"
 	"github.com/fatedier/frp/pkg/config"
 	"github.com/spf13/cobra"
@@ -18,6 +28,12 @@ import (
 
 func StartCmd() *cobra.Command {
 	var port int
+	var metricsPort int
+	var metricsInsecure bool
+	var healthAddr string
+	var drainTimeout time.Duration
+	var frpcMode string
+	var dashboardAddr, dashboardUser, dashboardPwd string
 	var frpcAdminAddr string
 	var frpcAdminPort int
 	var frpcLogLevel string
@@ -41,11 +57,31 @@ func StartCmd() *cobra.Command {
 			}
 			cfg.Token = frpsToken
 
-			return Start(port, cfg)
+			var dashboard *stats.Dashboard
+			if dashboardAddr != "" {
+				dashboard = stats.NewDashboard(dashboardAddr, dashboardUser, dashboardPwd)
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if frpcMode != "exec" && frpcMode != "embedded" {
+				return fmt.Errorf("invalid frpc mode: %s", frpcMode)
+			}
+
+			return Start(ctx, port, metricsPort, metricsInsecure, healthAddr, drainTimeout, frpcMode == "embedded", dashboard, cfg)
 		},
 	}
 
 	startCmd.Flags().IntVarP(&port, "port", "p", api.DefaultPort, "port to listen on")
+	startCmd.Flags().IntVar(&metricsPort, "metrics-port", 0, "port to serve prometheus metrics on, 0 to disable")
+	startCmd.Flags().BoolVar(&metricsInsecure, "metrics-insecure", false, "serve metrics over plain http without client certificates")
+	startCmd.Flags().StringVar(&healthAddr, "health-addr", "", "address to serve /healthz and /readyz on over plain http, e.g. 127.0.0.1:8702")
+	startCmd.Flags().DurationVar(&drainTimeout, "drain-timeout", 10*time.Second, "how long to wait for in-flight requests to finish when shutting down")
+	startCmd.Flags().StringVar(&dashboardAddr, "frps-dashboard-addr", "", "frps dashboard url to sample tunnel traffic from, e.g. http://frps.example.com:7500")
+	startCmd.Flags().StringVar(&dashboardUser, "frps-dashboard-user", "", "frps dashboard user")
+	startCmd.Flags().StringVar(&dashboardPwd, "frps-dashboard-pwd", "", "frps dashboard password")
+	startCmd.Flags().StringVar(&frpcMode, "frpc-mode", "exec", "how to run frpc: exec runs the frpc binary, embedded runs it in the tfarmd process")
 	startCmd.Flags().StringVar(&frpcAdminAddr, "frpc-admin-addr", "127.0.0.1", "address of frpc admin interface")
 	startCmd.Flags().IntVar(&frpcAdminPort, "frpc-admin-port", 7400, "frpc admin port")
 	startCmd.Flags().StringVar(&frpcLogLevel, "frpc-log-level", "info", "frpc log level")
@@ -56,20 +92,35 @@ func StartCmd() *cobra.Command {
 	return startCmd
 }
 
-func Start(port int, cfg config.ClientCommonConf) error {
-	log.Printf("starting tfarmd version %s", version.Version)
+// Start runs tfarmd until ctx is done or a server fails, then shuts it down,
+// giving in-flight requests up to drainTimeout to finish. frpc runs in
+// tfarmd's process if embedded is set. Traffic stats are sampled from the
+// frps dashboard unless it is nil.
+func Start(ctx context.Context, port, metricsPort int, metricsInsecure bool, healthAddr string, drainTimeout time.Duration, embedded bool, dashboard *stats.Dashboard, cfg config.ClientCommonConf) error {
+	logger, err := logging.New(os.Stderr, os.Getenv("TFARMD_LOG_LEVEL"), os.Getenv("TFARMD_LOG_FORMAT"))
+	if err != nil {
+		return fmt.Errorf("error setting up logging: %s", err)
+	}
+	// this also sends anything logged with the log package through the logger
+	slog.SetDefault(logger)
 
-	frpcBinPath := os.Getenv("TFARMD_FRPC_BIN_PATH")
-	if frpcBinPath == "" {
-		userFrpcPath, err := exec.LookPath("frpc")
-		if err != nil {
-			return fmt.Errorf("error looking for frpc binary in $PATH: %s", err)
+	slog.Info("starting tfarmd", "version", version.Version)
+
+	// the binary isn't needed when frpc is embedded
+	frpcBinPath := ""
+	if !embedded {
+		frpcBinPath = os.Getenv("TFARMD_FRPC_BIN_PATH")
+		if frpcBinPath == "" {
+			userFrpcPath, err := exec.LookPath("frpc")
+			if err != nil {
+				return fmt.Errorf("error looking for frpc binary in $PATH: %s", err)
+			}
+			frpcBinPath = userFrpcPath
 		}
-		frpcBinPath = userFrpcPath
-	}
 
-	if _, err := os.Stat(frpcBinPath); os.IsNotExist(err) {
-		return fmt.Errorf("frpc binary not found at %s", frpcBinPath)
+		if _, err := os.Stat(frpcBinPath); os.IsNotExist(err) {
+			return fmt.Errorf("frpc binary not found at %s", frpcBinPath)
+		}
 	}
 
 	workDir := os.Getenv("TFARMD_WORK_DIR")
@@ -85,17 +136,47 @@ func Start(port int, cfg config.ClientCommonConf) error {
 		return fmt.Errorf("work directory not found at %s", workDir)
 	}
 
-	f, err := frpc.New(frpcBinPath, workDir, cfg)
+	var f *frpc.Frpc
+	if embedded {
+		f, err = frpc.NewEmbedded(workDir, cfg)
+	} else {
+		f, err = frpc.New(frpcBinPath, workDir, cfg)
+	}
 	if err != nil {
 		return fmt.Errorf("error setting up frpc: %s", err)
 	}
+	f.Events = events.NewBus()
+	b := backend.NewFrpc(f)
+
+	s, err := state.Open(path.Join(workDir, "tunnels.json"))
+	if err != nil {
+		return fmt.Errorf("error opening tunnel state: %s", err)
+	}
 
-	h := handlers.NewMuxHandler(f)
+	configs, err := b.Tunnels()
+	if err != nil {
+		return fmt.Errorf("error reading tunnel configs: %s", err)
+	}
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	if err := s.Prune(names); err != nil {
+		return fmt.Errorf("error pruning tunnel state: %s", err)
+	}
+
+	var rec *stats.Recorder
+	if dashboard != nil {
+		// an hour of samples
+		rec = stats.NewRecorder(360)
+	}
+
+	h := handlers.NewMuxHandler(b, s, rec)
 
 	tlsDir := path.Join(workDir, "tls")
 	if _, err := os.Stat(tlsDir); err != nil {
 		if os.IsNotExist(err) {
-			log.Println("tls directory not found, generating certificates")
+			slog.Info("tls directory not found, generating certificates")
 			if err := certs.GenerateServerCerts(tlsDir); err != nil {
 				return fmt.Errorf("error generating tls certificates: %s", err)
 			}
@@ -115,14 +196,86 @@ func Start(port int, cfg config.ClientCommonConf) error {
 		return fmt.Errorf("error starting api server: %s", err)
 	}
 	a.Start()
+	servers := []*api.APIServer{a}
+
+	// a nil channel never receives, so the select below ignores it when metrics are disabled
+	var metricsErrChan chan error
+	if metricsPort != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", handlers.NewMetricsHandler(b, s, rec))
 
-	f.StartLoop()
+		var m *api.APIServer
+		if metricsInsecure {
+			m = api.NewInsecureServer(mux, fmt.Sprintf(":%d", metricsPort))
+		} else {
+			m, err = api.NewServer(mux, metricsPort, tls)
+			if err != nil {
+				return fmt.Errorf("error starting metrics server: %s", err)
+			}
+		}
+		m.Start()
+		metricsErrChan = m.ErrChan
+		servers = append(servers, m)
+	}
+
+	var healthErrChan chan error
+	if healthAddr != "" {
+		hs := api.NewInsecureServer(handlers.NewHealthHandler(b), healthAddr)
+		hs.Start()
+		healthErrChan = hs.ErrChan
+		servers = append(servers, hs)
+	}
+
+	b.Start()
+	loops := []func(){
+		handlers.StartReaper(b, s, 5*time.Second),
+		handlers.StartStatusWatcher(b, 2*time.Second),
+	}
+	if rec != nil {
+		loops = append(loops, handlers.StartStatsSampler(b, cfg.User, dashboard, rec, 10*time.Second))
+	}
 
+	var exitErr error
 	select {
-	case err := <-f.StartErrChan:
-		return fmt.Errorf("error starting frpc: %s", err)
+	case <-ctx.Done():
+		slog.Info("received shutdown signal")
 	case err := <-a.ErrChan:
-		return fmt.Errorf("api server exited: %s", err)
+		exitErr = fmt.Errorf("api server exited: %s", err)
+	case err := <-metricsErrChan:
+		exitErr = fmt.Errorf("metrics server exited: %s", err)
+	case err := <-healthErrChan:
+		exitErr = fmt.Errorf("health server exited: %s", err)
 	}
 
+	shutdown(b, servers, loops, drainTimeout)
+	if exitErr == nil {
+		slog.Info("tfarmd stopped")
+	}
+	return exitErr
+}
+
+// shutdown stops accepting requests, waits up to drainTimeout for in-flight
+// ones, stops the background loops, then stops frpc.
+func shutdown(b backend.Backend, servers []*api.APIServer, loops []func(), drainTimeout time.Duration) {
+	slog.Info("shutting down", "drain_timeout", drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Warn("error draining server", "err", err)
+		}
+	}
+
+	// the reaper deletes tunnels, so it must not be halfway through one either
+	for _, stop := range loops {
+		stop()
+	}
+
+	// wait for any reload to finish so frpc isn't stopped halfway through one
+	b.Lock()
+	defer b.Unlock()
+	if err := b.Stop(); err != nil {
+		slog.Error("error stopping frpc", "err", err)
+	}
 }
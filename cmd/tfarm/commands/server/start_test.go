@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/certs"
+)
+
+// fakeFrpc logs what it is asked to do to frpc.log in the work dir. verify
+// is slow so a request can be in flight when tfarmd is told to stop.
+const fakeFrpc = `#!/bin/sh
+log="$PWD/frpc.log"
+case "$1" in
+verify)
+	echo verify >> "$log"
+	sleep 1
+	;;
+reload)
+	echo reload >> "$log"
+	;;
+*)
+	trap 'echo "stopped INT" >> "$log"; exit 0' INT
+	trap 'echo "stopped TERM" >> "$log"; exit 0' TERM
+	echo started >> "$log"
+	echo "login to server success, get run id [fake]"
+	while true; do sleep 0.1; done
+	;;
+esac
+`
+
+// fakeWorkDir sets up a configured work dir with the fake frpc for tfarm
+// server start to use, and returns it.
+func fakeWorkDir(t *testing.T) string {
+	t.Helper()
+
+	workDir := t.TempDir()
+	binPath := filepath.Join(workDir, "frpc")
+	if err := os.WriteFile(binPath, []byte(fakeFrpc), 0755); err != nil {
+		t.Fatal(err)
+	}
+	creds := `{"client_id":"test","client_secret":"c2VjcmV0","client_ca_cert":"Y2E=","client_tls_cert":"Y2VydA==","client_tls_key":"a2V5"}`
+	if err := os.WriteFile(filepath.Join(workDir, "credentials.json"), []byte(creds), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tlsDir := filepath.Join(workDir, "tls")
+	if err := certs.GenerateServerCerts(tlsDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.GenerateClientCerts(tlsDir, "test"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TFARMD_WORK_DIR", workDir)
+	t.Setenv("TFARMD_FRPC_BIN_PATH", binPath)
+	t.Setenv("TFARMD_LOG_LEVEL", "error")
+
+	return workDir
+}
+
+// runStart runs tfarm server start with args in the background and returns
+// the error it exits with.
+func runStart(t *testing.T, args ...string) <-chan error {
+	t.Helper()
+	cmd := StartCmd()
+	cmd.SetArgs(append(args, "--frpc-admin-port", fmt.Sprint(freePort(t))))
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.ExecuteContext(context.Background())
+	}()
+	return exited
+}
+
+// startFakeTfarmd runs tfarm server start against the fake frpc and waits
+// for it to serve. It returns a client for its api, the work dir and the
+// error tfarmd exits with.
+func startFakeTfarmd(t *testing.T) (*api.APIClient, string, <-chan error) {
+	t.Helper()
+
+	workDir := fakeWorkDir(t)
+	port := freePort(t)
+	exited := runStart(t, "--port", fmt.Sprint(port))
+
+	client, err := api.NewClient(fmt.Sprintf("https://localhost:%d", port), filepath.Join(workDir, "tls", "clients", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "tfarmd to start", func() bool {
+		info := client.Info(context.Background())
+		return info.Server.Error == "" && strings.Contains(readFile(t, filepath.Join(workDir, "frpc.log")), "started")
+	})
+
+	return client, workDir, exited
+}
+
+func TestStartStopsOnSignal(t *testing.T) {
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGINT} {
+		t.Run(sig.String(), func(t *testing.T) {
+			client, workDir, exited := startFakeTfarmd(t)
+			frpcLog := filepath.Join(workDir, "frpc.log")
+
+			created := make(chan error, 1)
+			go func() {
+				_, err := client.Create(context.Background(), &api.CreateRequest{
+					Name:      "web",
+					Type:      "http",
+					LocalIP:   "127.0.0.1",
+					LocalPort: 8080,
+				})
+				created <- err
+			}()
+			waitFor(t, "the tunnel to be verified", func() bool {
+				return strings.Contains(readFile(t, frpcLog), "verify")
+			})
+
+			if err := syscall.Kill(os.Getpid(), sig); err != nil {
+				t.Fatal(err)
+			}
+
+			// the request in flight is drained rather than cut off
+			if err := <-created; err != nil {
+				t.Fatalf("create: %s", err)
+			}
+
+			select {
+			case err := <-exited:
+				if err != nil {
+					t.Fatalf("tfarmd exited with %s, want no error", err)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatal("tfarmd did not stop")
+			}
+
+			// frpc is only stopped once the request has applied its change,
+			// and is always interrupted
+			want := "started\nverify\nreload\nstopped INT"
+			if got := strings.TrimSpace(readFile(t, frpcLog)); got != want {
+				t.Fatalf("frpc log = %q, want %q", got, want)
+			}
+			if _, err := os.Stat(filepath.Join(workDir, "conf.d", "web.ini")); err != nil {
+				t.Fatalf("tunnel config: %s", err)
+			}
+		})
+	}
+}
+
+func TestStartExitsWhenAServerFails(t *testing.T) {
+	workDir := fakeWorkDir(t)
+
+	// the health server can't listen on a port that is taken
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	exited := runStart(t, "--port", fmt.Sprint(freePort(t)), "--health-addr", l.Addr().String())
+	select {
+	case err := <-exited:
+		if err == nil || !strings.Contains(err.Error(), "health server exited") {
+			t.Fatalf("tfarmd exited with %v, want the health server's error", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("tfarmd did not exit")
+	}
+
+	// frpc is still stopped on the way out if it got to start
+	if log := readFile(t, filepath.Join(workDir, "frpc.log")); strings.Contains(log, "started") && !strings.Contains(log, "stopped INT") {
+		t.Fatalf("frpc was not stopped, frpc log = %q", log)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/term"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+func StatusCmd() *cobra.Command {
+	var outputFormat string
+
+	statusCmd := &cobra.Command{
+		Use:           "status",
+		Short:         "Show the state of frpc and why it last exited",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Status(outputFormat)
+		},
+	}
+
+	statusCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format (text, json, yaml)")
+
+	return statusCmd
+}
+
+func Status(outputFormat string) error {
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	status, err := client.Frpc(context.Background())
+	if err != nil {
+		return fmt.Errorf("error getting frpc status: %s", err)
+	}
+
+	switch outputFormat {
+	case "text":
+		state := status.State
+		if status.Degraded {
+			state += " (degraded)"
+		}
+		fmt.Println("State:", state)
+		if status.PID != 0 {
+			fmt.Println("PID:", status.PID)
+		}
+		if status.StartedAt != nil {
+			fmt.Printf("Started: %s (up %s)\n", status.StartedAt.Local().Format(time.RFC3339), time.Since(*status.StartedAt).Round(time.Second))
+		}
+		if status.NextStart != nil && status.State == api.FrpcStateBackoff {
+			fmt.Printf("Next start: %s (in %s)\n", status.NextStart.Local().Format(time.RFC3339), time.Until(*status.NextStart).Round(time.Second))
+		}
+		fmt.Println("Starts:", status.Starts)
+		fmt.Println("Failures in a row:", status.ConsecutiveFailures)
+
+		if len(status.Exits) == 0 {
+			return nil
+		}
+		fmt.Println()
+		tbl := table.New("Time", "Exit Code", "Uptime", "Error").WithWriter(os.Stdout)
+		for _, exit := range status.Exits {
+			uptime := time.Duration(exit.UptimeSeconds * float64(time.Second)).Round(time.Second)
+			tbl.AddRow(exit.Time.Local().Format(time.RFC3339), exit.ExitCode, uptime, exit.Error)
+		}
+		tbl.Print()
+
+		last := status.Exits[len(status.Exits)-1]
+		if len(last.Output) > 0 {
+			fmt.Println()
+			fmt.Println("Last output:")
+			for _, line := range last.Output {
+				fmt.Println(" ", line)
+			}
+		}
+	case "json":
+		b, err := term.PrettyJSON(status)
+		if err != nil {
+			return fmt.Errorf("error marshaling status to json: %s", err)
+		}
+		fmt.Println(string(b))
+	case "yaml":
+		b, err := term.PrettyYAML(status)
+		if err != nil {
+			return fmt.Errorf("error marshaling status to yaml: %s", err)
+		}
+		fmt.Print(string(b))
+	default:
+		return fmt.Errorf("invalid output format: %s", outputFormat)
+	}
+
+	return nil
+}
+
+// getClient is the same as the one for the top-level commands, which this
+// package can't import.
+func getClient() (*api.APIClient, error) {
+	endpoint := os.Getenv("TFARM_API_ENDPOINT")
+	if endpoint == "" {
+		endpoint = api.DefaultEndpoint
+	}
+
+	configDir := os.Getenv("TFARM_CONFIG_DIR")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("error getting user's home directory: %s", err)
+		}
+
+		configDir = path.Join(home, ".tfarm")
+	}
+
+	return api.NewClient(endpoint, configDir)
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func ShareCmd() *cobra.Command {
+	var name, tunnelType, stripPrefix, httpUser, httpPwd string
+
+	shareCmd := &cobra.Command{
+		Use:           "share [DIR]",
+		Short:         "Share a directory through a static file tunnel",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("directory is required")
+			}
+			return Share(args[0], name, tunnelType, stripPrefix, httpUser, httpPwd)
+		},
+	}
+
+	shareCmd.Flags().StringVarP(&name, "name", "n", "", "tunnel name (defaults to the directory name)")
+	shareCmd.Flags().StringVarP(&tunnelType, "type", "t", "http", "tunnel type (http, https, tcp, stcp, xtcp)")
+	shareCmd.Flags().StringVar(&stripPrefix, "strip-prefix", "", "url path prefix to strip before looking up files")
+	shareCmd.Flags().StringVar(&httpUser, "http-user", "", "basic auth user")
+	shareCmd.Flags().StringVar(&httpPwd, "http-pwd", "", "basic auth password")
+
+	return shareCmd
+}
+
+// Share creates a tunnel served by the static_file plugin. The directory
+// is resolved locally, so the tfarm server must run on the same host.
+func Share(dir, name, tunnelType, stripPrefix, httpUser, httpPwd string) error {
+	path, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("error resolving directory: %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error reading directory: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	if name == "" {
+		name = filepath.Base(path)
+	}
+
+	params := map[string]string{"local_path": path}
+	if stripPrefix != "" {
+		params["strip_prefix"] = stripPrefix
+	}
+	if httpUser != "" || httpPwd != "" {
+		params["http_user"] = httpUser
+		params["http_passwd"] = httpPwd
+	}
+
+	return Create(&api.CreateRequest{
+		Name: name,
+		Type: tunnelType,
+		Plugin: &api.Plugin{
+			Name:   "static_file",
+			Params: params,
+		},
+	}, 0)
+}
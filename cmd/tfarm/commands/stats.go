@@ -0,0 +1,232 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/term"
+	"github.com/rodaine/table"
+	"github.com/spf13/cobra"
+)
+
+func StatsCmd() *cobra.Command {
+	var watch bool
+	var interval time.Duration
+	var outputFormat string
+
+	statsCmd := &cobra.Command{
+		Use:           "stats [NAME]",
+		Short:         "Show tunnel traffic",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("at most one name is allowed")
+			}
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if watch && outputFormat != "table" {
+				return fmt.Errorf("--watch only supports table output")
+			}
+			return Stats(name, watch, interval, outputFormat)
+		},
+	}
+
+	statsCmd.Flags().BoolVarP(&watch, "watch", "w", false, "refresh until interrupted")
+	statsCmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "refresh interval with --watch")
+	statsCmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format (table, json, yaml)")
+
+	return statsCmd
+}
+
+func Stats(name string, watch bool, interval time.Duration, outputFormat string) error {
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	show := func() error {
+		var res interface{}
+		if name == "" {
+			all, err := client.Stats(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting stats: %s", err)
+			}
+			res = all
+		} else {
+			tunnel, err := client.TunnelStats(ctx, &api.GetRequest{Name: name})
+			if err != nil {
+				return fmt.Errorf("error getting stats: %s", err)
+			}
+			res = tunnel
+		}
+
+		switch outputFormat {
+		case "table":
+			if watch {
+				// clear the screen and move the cursor home
+				fmt.Print("\033[H\033[2J")
+			}
+			switch res := res.(type) {
+			case *api.StatsResponse:
+				printStatsTable(res.Tunnels)
+			case *api.TunnelStats:
+				printTunnelStats(res)
+			}
+		case "json":
+			b, err := term.PrettyJSON(res)
+			if err != nil {
+				return fmt.Errorf("error marshaling stats to json: %s", err)
+			}
+			fmt.Println(string(b))
+		case "yaml":
+			b, err := term.PrettyYAML(res)
+			if err != nil {
+				return fmt.Errorf("error marshaling stats to yaml: %s", err)
+			}
+			fmt.Print(string(b))
+		default:
+			return fmt.Errorf("invalid output format: %s", outputFormat)
+		}
+		return nil
+	}
+
+	if err := show(); err != nil || !watch {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := show(); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+func printStatsTable(tunnels []api.TunnelStats) {
+	tbl := table.New("Name", "In", "Out", "Conns", "In Today", "Out Today").WithWriter(os.Stdout)
+	for _, t := range tunnels {
+		if len(t.Samples) == 0 {
+			continue
+		}
+		in, out := trafficRates(t.Samples)
+		last := t.Samples[len(t.Samples)-1]
+		tbl.AddRow(t.Name, formatRate(in), formatRate(out), last.Conns, formatBytes(last.TrafficIn), formatBytes(last.TrafficOut))
+	}
+	tbl.Print()
+}
+
+// sparklineWidth is how many of the most recent samples sparklines show.
+const sparklineWidth = 60
+
+func printTunnelStats(t *api.TunnelStats) {
+	if len(t.Samples) == 0 {
+		fmt.Printf("no traffic recorded for tunnel %s yet\n", t.Name)
+		return
+	}
+
+	in, out := trafficRates(t.Samples)
+	conns := make([]float64, len(t.Samples))
+	for i, s := range t.Samples {
+		conns[i] = float64(s.Conns)
+	}
+	last := t.Samples[len(t.Samples)-1]
+
+	fmt.Printf("%s, last sampled %s\n", t.Name, last.Time.Local().Format(time.RFC3339))
+	fmt.Printf("in     %s  %s (%s today)\n", sparkline(in), formatRate(in), formatBytes(last.TrafficIn))
+	fmt.Printf("out    %s  %s (%s today)\n", sparkline(out), formatRate(out), formatBytes(last.TrafficOut))
+	fmt.Printf("conns  %s  %d\n", sparkline(conns), last.Conns)
+}
+
+// trafficRates returns the bytes per second between consecutive samples.
+// The counters reset daily, so a drop counts as no traffic.
+func trafficRates(samples []api.TrafficSample) (in, out []float64) {
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		seconds := cur.Time.Sub(prev.Time).Seconds()
+		if seconds <= 0 {
+			continue
+		}
+		in = append(in, float64(max64(cur.TrafficIn-prev.TrafficIn, 0))/seconds)
+		out = append(out, float64(max64(cur.TrafficOut-prev.TrafficOut, 0))/seconds)
+	}
+	return in, out
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders the most recent values scaled to the largest of them.
+func sparkline(values []float64) string {
+	if len(values) > sparklineWidth {
+		values = values[len(values)-sparklineWidth:]
+	}
+
+	var peak float64
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if peak > 0 {
+			i = int(v / peak * float64(len(sparkRunes)-1))
+		}
+		b.WriteRune(sparkRunes[i])
+	}
+	// pad so the columns after it line up
+	for i := len(values); i < sparklineWidth; i++ {
+		b.WriteRune(' ')
+	}
+	return b.String()
+}
+
+// formatRate formats the most recent of the rates.
+func formatRate(rates []float64) string {
+	if len(rates) == 0 {
+		return "-"
+	}
+	return formatBytes(int64(rates[len(rates)-1])) + "/s"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
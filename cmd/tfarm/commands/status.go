@@ -1,42 +1,78 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"os"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/term"
+	"github.com/rodaine/table"
 	"github.com/spf13/cobra"
 )
 
 func StatusCmd() *cobra.Command {
+	var outputFormat string
+
 	statusCmd := &cobra.Command{
 		Use:           "status",
 		Short:         "Get the status of all tunnels",
 		SilenceUsage:  true,
 		SilenceErrors: false,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return Status()
+			return Status(outputFormat)
 		},
 	}
 
+	statusCmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format (table, json, yaml)")
+
 	return statusCmd
 }
 
-func Status() error {
+func Status(outputFormat string) error {
 	client, err := getClient()
 	if err != nil {
 		return fmt.Errorf("error creating client: %s", err)
 	}
 
+	ctx := context.Background()
+
 	req := &api.APIRequest{}
-	status, err := client.Status(req)
+	status, err := client.Status(ctx, req)
 	if err != nil {
 		return fmt.Errorf("error getting status: %s", err)
 	}
 
-	if status.Success {
-		fmt.Print(status.Message)
-	} else {
-		fmt.Println(status.Error)
+	switch outputFormat {
+	case "table":
+		tbl := table.New("Name", "Type", "Status", "Local", "Remote", "Error").WithWriter(os.Stdout)
+		for _, ps := range status.Proxies {
+			local, remote := ps.LocalAddr, ps.RemoteAddr
+			if ps.Type == "http" || ps.Type == "https" {
+				if local != "" {
+					local = fmt.Sprintf("%s://%s", ps.Type, local)
+				}
+				if remote != "" {
+					remote = fmt.Sprintf("%s://%s", ps.Type, remote)
+				}
+			}
+			tbl.AddRow(ps.Name, ps.Type, ps.Status, local, remote, ps.Error)
+		}
+		tbl.Print()
+	case "json":
+		b, err := term.PrettyJSON(status)
+		if err != nil {
+			return fmt.Errorf("error marshaling status to json: %s", err)
+		}
+		fmt.Println(string(b))
+	case "yaml":
+		b, err := term.PrettyYAML(status)
+		if err != nil {
+			return fmt.Errorf("error marshaling status to yaml: %s", err)
+		}
+		fmt.Print(string(b))
+	default:
+		return fmt.Errorf("invalid output format: %s", outputFormat)
 	}
 
 	return nil
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func UpdateCmd() *cobra.Command {
+	req := &api.UpdateRequest{}
+	var labels map[string]string
+	var clearLabels bool
+	var ttl time.Duration
+
+	updateCmd := &cobra.Command{
+		Use:           "update [NAME]",
+		Short:         "Update an existing tunnel",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("name is required")
+			}
+			if cmd.Flags().NFlag() == 0 {
+				return fmt.Errorf("at least one of --local-ip, --local-port, --remote-port, --label, --clear-labels, --description or --ttl is required")
+			}
+			req.Name = args[0]
+			switch {
+			case clearLabels && labels != nil:
+				return fmt.Errorf("--label and --clear-labels can't be used together")
+			case clearLabels:
+				req.Labels = &map[string]string{}
+			case labels != nil:
+				req.Labels = &labels
+			}
+			if ttl > 0 {
+				req.TTL = ttl.String()
+			}
+			return Update(req)
+		},
+	}
+
+	updateCmd.Flags().StringVarP(&req.LocalIP, "local-ip", "l", "", "local ip address")
+	updateCmd.Flags().IntVarP(&req.LocalPort, "local-port", "p", 0, "local port")
+	updateCmd.Flags().IntVarP(&req.RemotePort, "remote-port", "r", 0, "remote port (tcp and udp only)")
+	updateCmd.Flags().StringToStringVar(&labels, "label", nil, "label as KEY=VALUE, can be repeated, replaces all labels")
+	updateCmd.Flags().BoolVar(&clearLabels, "clear-labels", false, "remove all labels")
+	updateCmd.Flags().StringVar(&req.Description, "description", "", "description of the tunnel")
+	updateCmd.Flags().DurationVar(&ttl, "ttl", 0, "delete the tunnel this long after it was created, e.g. 2h")
+
+	return updateCmd
+}
+
+func Update(req *api.UpdateRequest) error {
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx := context.Background()
+
+	status, err := client.Update(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error updating: %s", err)
+	}
+
+	fmt.Println(status.Message)
+
+	return nil
+}
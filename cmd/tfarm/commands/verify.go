@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
@@ -27,17 +28,15 @@ func Verify() error {
 		return fmt.Errorf("error creating client: %s", err)
 	}
 
+	ctx := context.Background()
+
 	req := &api.APIRequest{}
-	status, err := client.Verify(req)
+	result, err := client.Verify(ctx, req)
 	if err != nil {
 		return fmt.Errorf("error verifying: %s", err)
 	}
 
-	if status.Success {
-		fmt.Println(status.Message)
-	} else {
-		fmt.Println(status.Error)
-	}
+	fmt.Println(result.Output)
 
 	return nil
 }
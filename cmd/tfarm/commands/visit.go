@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func VisitCmd() *cobra.Command {
+	req := &api.VisitorRequest{}
+
+	visitCmd := &cobra.Command{
+		Use:           "visit [NAME]",
+		Short:         "Create a visitor for another client's secret tunnel",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("name is required")
+			}
+			req.Name = args[0]
+			return Visit(req)
+		},
+	}
+
+	visitCmd.Flags().StringVarP(&req.Type, "type", "t", "stcp", "tunnel type (stcp, xtcp, sudp)")
+	visitCmd.Flags().StringVar(&req.ServerName, "server-name", "", "name of the tunnel to visit (required)")
+	visitCmd.Flags().StringVar(&req.ServerUser, "server-user", "", "user that owns the tunnel to visit")
+	visitCmd.Flags().StringVar(&req.SecretKey, "sk", "", "secret key of the tunnel to visit (required)")
+	visitCmd.Flags().StringVar(&req.BindAddr, "bind-addr", "127.0.0.1", "local address to listen on")
+	visitCmd.Flags().IntVar(&req.BindPort, "bind-port", 0, "local port to listen on (required)")
+	visitCmd.Flags().StringToStringVar(&req.Labels, "label", nil, "label as KEY=VALUE, can be repeated")
+	visitCmd.Flags().StringVar(&req.Description, "description", "", "description of the visitor")
+
+	return visitCmd
+}
+
+func Visit(req *api.VisitorRequest) error {
+	if req.ServerName == "" {
+		return fmt.Errorf("server name is required")
+	}
+
+	if req.SecretKey == "" {
+		return fmt.Errorf("secret key is required")
+	}
+
+	if req.BindPort == 0 {
+		return fmt.Errorf("bind port is required")
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx := context.Background()
+
+	status, err := client.CreateVisitor(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error creating visitor: %s", err)
+	}
+
+	fmt.Println(status.Message)
+
+	return nil
+}
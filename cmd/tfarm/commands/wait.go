@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+func WaitCmd() *cobra.Command {
+	req := &api.WaitRequest{}
+
+	waitCmd := &cobra.Command{
+		Use:           "wait [NAME]",
+		Short:         "Wait for a tunnel to reach a status",
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("name is required")
+			}
+			req.Name = args[0]
+			return Wait(req)
+		},
+	}
+
+	waitCmd.Flags().StringVar(&req.For, "for", "running", "status to wait for (running, closed, ...)")
+	waitCmd.Flags().DurationVar(&req.Timeout, "timeout", api.DefaultWaitTimeout, "how long to wait")
+
+	return waitCmd
+}
+
+func Wait(req *api.WaitRequest) error {
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err)
+	}
+
+	ctx := context.Background()
+
+	status, err := client.Wait(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error waiting for tunnel %s: %s", req.Name, err)
+	}
+
+	if status.RemoteAddr != "" {
+		fmt.Printf("tunnel %s %s at %s\n", req.Name, status.Status, status.RemoteAddr)
+	} else {
+		fmt.Printf("tunnel %s %s\n", req.Name, status.Status)
+	}
+
+	return nil
+}
@@ -1,14 +1,19 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"net/url"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/cbodonnell/tfarm/pkg/auth"
 	"github.com/cbodonnell/tfarm/pkg/certs"
@@ -42,23 +47,219 @@ type ServerInfoResponse struct {
 	Version string `json:"version"`
 }
 
+type StatusResponse struct {
+	Proxies []ProxyStatus `json:"proxies" yaml:"proxies"`
+}
+
+type ProxyStatus struct {
+	Name       string `json:"name" yaml:"name"`
+	Type       string `json:"type" yaml:"type"`
+	Status     string `json:"status" yaml:"status"`
+	LocalAddr  string `json:"local_addr,omitempty" yaml:"local_addr,omitempty"`
+	Plugin     string `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty" yaml:"remote_addr,omitempty"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type VerifyResponse struct {
+	Output string `json:"output"`
+}
+
 type APIResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
-	Error   string `json:"error,omitempty"`
+	APIVersion string          `json:"api_version"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// DecodeData decodes the data field of the response into v.
+func (r *APIResponse) DecodeData(v interface{}) error {
+	if len(r.Data) == 0 {
+		return fmt.Errorf("response has no data")
+	}
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		return fmt.Errorf("failed to decode response data: %s", err)
+	}
+	return nil
 }
 
 type APIRequest struct {
 	// nothing
 }
 
+// ListRequest filters the tunnels listed. Selector is a comma-separated
+// list of label requirements like env=preview or team!=infra.
+type ListRequest struct {
+	Selector string
+}
+
+// APIError is returned when the tfarm server responds with a non-2xx status code.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Data       json.RawMessage // optional details of the failure
+}
+
+// DecodeData unmarshals the data sent along with the error into v.
+func (e *APIError) DecodeData(v interface{}) error {
+	if len(e.Data) == 0 {
+		return fmt.Errorf("error response has no data")
+	}
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("failed to decode error data: %s", err)
+	}
+	return nil
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("%s (status code: %d)", e.Message, e.StatusCode)
+}
+
 type CreateRequest struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	LocalIP    string   `json:"local_ip"`
+	LocalPort  int      `json:"local_port"`
+	RemotePort int      `json:"remote_port,omitempty"`
+	SecretKey  string   `json:"sk,omitempty"`          // stcp, xtcp and sudp only
+	AllowUsers []string `json:"allow_users,omitempty"` // stcp, xtcp and sudp only
+
+	CustomDomains     []string          `json:"custom_domains,omitempty"`      // http and https only
+	Locations         []string          `json:"locations,omitempty"`           // http only
+	HostHeaderRewrite string            `json:"host_header_rewrite,omitempty"` // http only
+	HTTPUser          string            `json:"http_user,omitempty"`           // http only
+	HTTPPwd           string            `json:"http_pwd,omitempty"`            // http only
+	Headers           map[string]string `json:"headers,omitempty"`             // http only, set on proxied requests
+
+	Plugin *Plugin `json:"plugin,omitempty"` // replaces local_ip and local_port
+
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+
+	// the tunnel is deleted once it expires, set at most one of these
+	TTL       string     `json:"ttl,omitempty"` // duration from creation, e.g. 2h
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Lease     string     `json:"lease,omitempty"` // duration the tunnel lives without being renewed
+
+	BandwidthLimit      string `json:"bandwidth_limit,omitempty"` // e.g. 512KB or 1MB
+	UseEncryption       bool   `json:"use_encryption,omitempty"`
+	UseCompression      bool   `json:"use_compression,omitempty"`
+	HealthCheckType     string `json:"health_check_type,omitempty"`       // tcp or http
+	HealthCheckURL      string `json:"health_check_url,omitempty"`        // path on the local service, http only
+	HealthCheckInterval int    `json:"health_check_interval_s,omitempty"` // seconds
+	Group               string `json:"group,omitempty"`                   // tcp and http only, load balances tunnels in the same group
+	GroupKey            string `json:"group_key,omitempty"`
+
+	ProxyID string `json:"-"` // assigned by tfarmd, never sent by clients
+}
+
+// Plugin configures an frpc client plugin that handles the tunnel's
+// connections instead of forwarding them to a local address.
+// Params are passed to the plugin without their plugin_ prefix,
+// e.g. local_path for static_file.
+type Plugin struct {
+	Name   string            `json:"name" yaml:"name"`
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// VisitorRequest creates a visitor that connects to a secret (stcp, xtcp, sudp)
+// tunnel of another client and exposes it on a local bind address.
+type VisitorRequest struct {
 	Name       string `json:"name"`
 	Type       string `json:"type"`
-	LocalIP    string `json:"local_ip"`
-	LocalPort  int    `json:"local_port"`
+	ServerName string `json:"server_name"`
+	ServerUser string `json:"server_user,omitempty"`
+	SecretKey  string `json:"sk"`
+	BindAddr   string `json:"bind_addr"`
+	BindPort   int    `json:"bind_port"`
+
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+// ApplyRequest converges the server's tunnels to the desired set.
+// Tunnels that differ from their current config are updated in place,
+// and with Prune, tunnels missing from the set are deleted.
+type ApplyRequest struct {
+	Tunnels []CreateRequest `json:"tunnels"`
+	DryRun  bool            `json:"dry_run,omitempty"`
+	Prune   bool            `json:"prune,omitempty"`
+}
+
+// ApplyResponse lists the tunnel names by the action taken, or that
+// would be taken for a dry run.
+type ApplyResponse struct {
+	Created   []string `json:"created" yaml:"created"`
+	Updated   []string `json:"updated" yaml:"updated"`
+	Deleted   []string `json:"deleted" yaml:"deleted"`
+	Unchanged []string `json:"unchanged" yaml:"unchanged"`
+	DryRun    bool     `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+}
+
+// BatchOperation is a single change in a batch. Create and update take
+// the full tunnel; update replaces the tunnel's config but keeps its proxy id.
+type BatchOperation struct {
+	Action string         `json:"action"` // create, update or delete
+	Name   string         `json:"name,omitempty"`
+	Tunnel *CreateRequest `json:"tunnel,omitempty"` // create and update only
+}
+
+// BatchRequest applies all operations as a single transaction: either
+// every operation takes effect or none do.
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchResult struct {
+	Action string `json:"action" yaml:"action"`
+	Name   string `json:"name" yaml:"name"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results" yaml:"results"`
+}
+
+// UpdateRequest changes the given fields of an existing tunnel.
+// Zero values leave the current setting unchanged.
+type UpdateRequest struct {
+	Name       string `json:"name"`
+	LocalIP    string `json:"local_ip,omitempty"`
+	LocalPort  int    `json:"local_port,omitempty"`
 	RemotePort int    `json:"remote_port,omitempty"`
-	ProxyID    string // client-side identifier
+
+	Labels      *map[string]string `json:"labels,omitempty"` // replaces all labels, an empty map clears them
+	Description string             `json:"description,omitempty"`
+
+	// replaces the expiry of the tunnel, set at most one of these
+	TTL       string     `json:"ttl,omitempty"` // duration from creation, e.g. 2h
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Lease     string     `json:"lease,omitempty"` // duration the tunnel lives without being renewed
+}
+
+type RenewRequest struct {
+	Name string `json:"name"`
+}
+
+// RenewResponse is the new expiry of a leased tunnel.
+type RenewResponse struct {
+	ExpiresAt time.Time `json:"expires_at" yaml:"expires_at"`
+}
+
+// WaitRequest waits for the named tunnel's proxy to reach a status. For
+// defaults to running and Timeout to DefaultWaitTimeout.
+type WaitRequest struct {
+	Name    string
+	For     string
+	Timeout time.Duration
+}
+
+type GetRequest struct {
+	Name string `json:"name"`
 }
 
 type DeleteRequest struct {
@@ -89,6 +290,7 @@ func NewClient(endpoint string, configDir string) (*APIClient, error) {
 		Transport: &http.Transport{
 			TLSClientConfig: tlsConfig,
 		},
+		Timeout: DefaultTimeout,
 	}
 
 	return &APIClient{
@@ -98,7 +300,66 @@ func NewClient(endpoint string, configDir string) (*APIClient, error) {
 	}, nil
 }
 
-func (c *APIClient) Info() *Info {
+// SetTimeout sets the timeout for each request made by the client.
+// A timeout of zero means no timeout.
+func (c *APIClient) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
+
+// Do sends a request to the tfarm server and decodes the response into out.
+// The body, if not nil, is encoded as JSON. A non-2xx response is returned
+// as an *APIError carrying the server's error message.
+func (c *APIClient) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return fmt.Errorf("failed to encode request body: %s", err)
+		}
+		reqBody = &buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %s", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response with status code %d: %s", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var response APIResponse
+		if err := json.Unmarshal(b, &response); err == nil {
+			apiErr.Message = response.Error
+			apiErr.Data = response.Data
+		} else {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		return apiErr
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			return fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *APIClient) Info(ctx context.Context) *Info {
 	info := &Info{
 		Client: ClientInfo{
 			Version: version.Version,
@@ -109,7 +370,7 @@ func (c *APIClient) Info() *Info {
 		},
 	}
 
-	if serverInfo, err := c.getServerInfo(); err != nil {
+	if serverInfo, err := c.getServerInfo(ctx); err != nil {
 		info.Server.Error = fmt.Sprintf("error getting info: %s", err)
 	} else {
 		info.Server.Version = serverInfo.Version
@@ -118,158 +379,326 @@ func (c *APIClient) Info() *Info {
 	return info
 }
 
-func (c *APIClient) getServerInfo() (*ServerInfoResponse, error) {
-	resp, err := c.httpClient.Get(c.endpoint + "/api/info")
-	if err != nil {
+func (c *APIClient) getServerInfo(ctx context.Context) (*ServerInfoResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodGet, "/api/info", nil, &response); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
+	serverInfo := &ServerInfoResponse{}
+	if err := response.DecodeData(serverInfo); err != nil {
+		return nil, err
+	}
+
+	return serverInfo, nil
+}
+
+func (c *APIClient) Status(ctx context.Context, req *APIRequest) (*StatusResponse, error) {
 	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if err := c.Do(ctx, http.MethodGet, "/api/status", nil, &response); err != nil {
+		return nil, err
 	}
 
-	serverInfo := &ServerInfoResponse{}
-	if err := json.Unmarshal([]byte(response.Message), serverInfo); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response message: %s", err)
+	status := &StatusResponse{}
+	if err := response.DecodeData(status); err != nil {
+		return nil, err
 	}
 
-	return serverInfo, nil
+	return status, nil
 }
 
-// TODO: Refactor this to use a generic Do method
-func (c *APIClient) Status(req *APIRequest) (*APIResponse, error) {
-	resp, err := c.httpClient.Get(c.endpoint + "/api/status")
-	if err != nil {
+func (c *APIClient) Verify(ctx context.Context, req *APIRequest) (*VerifyResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodGet, "/api/verify", nil, &response); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
+	result := &VerifyResponse{}
+	if err := response.DecodeData(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *APIClient) Reload(ctx context.Context, req *APIRequest) (*APIResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodPost, "/api/reload", nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *APIClient) Restart(ctx context.Context, req *APIRequest) (*APIResponse, error) {
 	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if err := c.Do(ctx, http.MethodPost, "/api/restart", nil, &response); err != nil {
+		return nil, err
 	}
+	return &response, nil
+}
 
+func (c *APIClient) Configure(ctx context.Context, credentials *auth.ConfigureCredentials) (*APIResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodPut, "/api/configure", credentials, &response); err != nil {
+		return nil, err
+	}
 	return &response, nil
 }
 
-func (c *APIClient) Verify(req *APIRequest) (*APIResponse, error) {
-	resp, err := c.httpClient.Get(c.endpoint + "/api/verify")
-	if err != nil {
+func (c *APIClient) Create(ctx context.Context, req *CreateRequest) (*APIResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodPost, "/api/tunnel", req, &response); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return &response, nil
+}
 
+func (c *APIClient) CreateVisitor(ctx context.Context, req *VisitorRequest) (*APIResponse, error) {
 	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if err := c.Do(ctx, http.MethodPost, "/api/visitor", req, &response); err != nil {
+		return nil, err
 	}
+	return &response, nil
+}
 
+func (c *APIClient) Delete(ctx context.Context, opts *DeleteRequest) (*APIResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodDelete, fmt.Sprintf("/api/tunnel/%s", opts.Name), nil, &response); err != nil {
+		return nil, err
+	}
 	return &response, nil
 }
 
-func (c *APIClient) Reload(req *APIRequest) (*APIResponse, error) {
-	resp, err := c.httpClient.Post(c.endpoint+"/api/reload", "application/json", nil)
-	if err != nil {
+func (c *APIClient) ListTunnels(ctx context.Context, req *ListRequest) (*TunnelsResponse, error) {
+	p := "/api/tunnels"
+	if req.Selector != "" {
+		p += "?" + url.Values{"selector": {req.Selector}}.Encode()
+	}
+
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodGet, p, nil, &response); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
+	tunnels := &TunnelsResponse{}
+	if err := response.DecodeData(tunnels); err != nil {
+		return nil, err
 	}
 
+	return tunnels, nil
+}
+
+func (c *APIClient) Apply(ctx context.Context, req *ApplyRequest) (*ApplyResponse, error) {
 	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if err := c.Do(ctx, http.MethodPost, "/api/apply", req, &response); err != nil {
+		return nil, err
 	}
 
-	return &response, nil
+	result := &ApplyResponse{}
+	if err := response.DecodeData(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-func (c *APIClient) Restart(req *APIRequest) (*APIResponse, error) {
-	resp, err := c.httpClient.Post(c.endpoint+"/api/restart", "application/json", nil)
-	if err != nil {
+// Batch applies the operations in a single transaction. If the batch is
+// rejected, the per-operation results can be decoded from the *APIError.
+func (c *APIClient) Batch(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodPost, "/api/tunnels/batch", req, &response); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
+	result := &BatchResponse{}
+	if err := response.DecodeData(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *APIClient) Get(ctx context.Context, opts *GetRequest) (*Tunnel, error) {
 	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/api/tunnel/%s", opts.Name), nil, &response); err != nil {
+		return nil, err
 	}
 
-	return &response, nil
+	tunnel := &Tunnel{}
+	if err := response.DecodeData(tunnel); err != nil {
+		return nil, err
+	}
+
+	return tunnel, nil
 }
 
-func (c *APIClient) Configure(credentials *auth.ConfigureCredentials) (*APIResponse, error) {
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(credentials); err != nil {
+// Renew extends the lease of a tunnel created with a lease.
+func (c *APIClient) Renew(ctx context.Context, opts *RenewRequest) (*RenewResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodPost, fmt.Sprintf("/api/tunnel/%s/renew", opts.Name), nil, &response); err != nil {
 		return nil, err
 	}
-	body := bytes.NewReader(buf.Bytes())
 
-	req, err := http.NewRequest("PUT", c.endpoint+"/api/configure", body)
-	if err != nil {
+	renew := &RenewResponse{}
+	if err := response.DecodeData(renew); err != nil {
 		return nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+	return renew, nil
+}
+
+// Frpc returns the state of the frpc process and its recent exits.
+func (c *APIClient) Frpc(ctx context.Context) (*FrpcStatus, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodGet, "/api/frpc", nil, &response); err != nil {
+		return nil, err
+	}
+
+	status := &FrpcStatus{}
+	if err := response.DecodeData(status); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
+	return status, nil
+}
+
+// Stats returns the recent traffic of all tunnels.
+func (c *APIClient) Stats(ctx context.Context) (*StatsResponse, error) {
 	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if err := c.Do(ctx, http.MethodGet, "/api/stats", nil, &response); err != nil {
+		return nil, err
 	}
 
-	return &response, nil
+	stats := &StatsResponse{}
+	if err := response.DecodeData(stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
 }
 
-func (c *APIClient) Create(req *CreateRequest) (*APIResponse, error) {
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+func (c *APIClient) TunnelStats(ctx context.Context, opts *GetRequest) (*TunnelStats, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("/api/tunnel/%s/stats", opts.Name), nil, &response); err != nil {
 		return nil, err
 	}
-	body := bytes.NewReader(buf.Bytes())
 
-	resp, err := c.httpClient.Post(c.endpoint+"/api/tunnel", "application/json", body)
-	if err != nil {
+	stats := &TunnelStats{}
+	if err := response.DecodeData(stats); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+
+	return stats, nil
+}
+
+// Wait blocks until the tunnel's proxy reaches the requested status. If the
+// proxy reports an error or the timeout runs out first, the returned
+// *APIError carries the proxy's last status as data.
+func (c *APIClient) Wait(ctx context.Context, req *WaitRequest) (*ProxyStatus, error) {
+	query := url.Values{}
+	if req.For != "" {
+		query.Set("for", req.For)
+	}
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = DefaultWaitTimeout
+	}
+	query.Set("timeout", timeout.String())
+	p := fmt.Sprintf("/api/tunnel/%s/wait?%s", req.Name, query.Encode())
+
+	// leave the server time to respond after its own timeout runs out
+	wc := c.withTimeout(timeout + DefaultTimeout)
 
 	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if err := wc.Do(ctx, http.MethodGet, p, nil, &response); err != nil {
+		return nil, err
+	}
+
+	status := &ProxyStatus{}
+	if err := response.DecodeData(status); err != nil {
+		return nil, err
 	}
 
+	return status, nil
+}
+
+// withTimeout returns a copy of the client with a different request timeout.
+func (c *APIClient) withTimeout(timeout time.Duration) *APIClient {
+	httpClient := *c.httpClient
+	httpClient.Timeout = timeout
+	wc := *c
+	wc.httpClient = &httpClient
+	return &wc
+}
+
+func (c *APIClient) Update(ctx context.Context, opts *UpdateRequest) (*APIResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodPatch, fmt.Sprintf("/api/tunnel/%s", opts.Name), opts, &response); err != nil {
+		return nil, err
+	}
 	return &response, nil
 }
 
-func (c *APIClient) Delete(opts *DeleteRequest) (*APIResponse, error) {
-	req, err := http.NewRequest("DELETE", c.endpoint+fmt.Sprintf("/api/tunnel/%s", opts.Name), nil)
-	if err != nil {
+// Events returns the most recent events.
+func (c *APIClient) Events(ctx context.Context) (*EventsResponse, error) {
+	var response APIResponse
+	if err := c.Do(ctx, http.MethodGet, "/api/events", nil, &response); err != nil {
 		return nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+	events := &EventsResponse{}
+	if err := response.DecodeData(events); err != nil {
 		return nil, err
 	}
+
+	return events, nil
+}
+
+// FollowEvents streams events published from now on, calling fn for each,
+// until the context is done, the server closes the stream or fn returns an
+// error.
+func (c *APIClient) FollowEvents(ctx context.Context, fn func(Event) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+"/api/events?follow=true", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %s", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// the stream is open for as long as the caller wants, so the request
+	// timeout can't apply
+	resp, err := c.withTimeout(0).httpClient.Do(req)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 
-	var response APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response with status code %d: %s", resp.StatusCode, err)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var response APIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err == nil {
+			apiErr.Message = response.Error
+		}
+		return apiErr
 	}
 
-	return &response, nil
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// the event type and id are repeated in the data, so only it is read
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to decode event: %s", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read events: %s", err)
+	}
+
+	return nil
 }
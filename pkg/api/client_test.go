@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestClient returns a client for a server that answers every request
+// with handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *APIClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &APIClient{endpoint: srv.URL, httpClient: srv.Client()}
+}
+
+func TestDoErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    *APIError
+		err     string
+	}{
+		{
+			name: "error response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				RespondWithError(w, http.StatusNotFound, "tunnel does not exist: web")
+			},
+			want: &APIError{StatusCode: http.StatusNotFound, Message: "tunnel does not exist: web"},
+			err:  "tunnel does not exist: web (status code: 404)",
+		},
+		{
+			name: "error response with data",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				RespondWithErrorData(w, http.StatusBadRequest, "1 of 1 operations failed", &BatchResponse{Results: []BatchResult{{Action: "rename", Name: "web", Error: "invalid action"}}})
+			},
+			want: &APIError{StatusCode: http.StatusBadRequest, Message: "1 of 1 operations failed", Data: []byte(`{"results":[{"action":"rename","name":"web","error":"invalid action"}]}`)},
+			err:  "1 of 1 operations failed (status code: 400)",
+		},
+		{
+			name: "body isn't json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "upstream unavailable", http.StatusBadGateway)
+			},
+			want: &APIError{StatusCode: http.StatusBadGateway, Message: "upstream unavailable"},
+			err:  "upstream unavailable (status code: 502)",
+		},
+		{
+			name: "empty body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			want: &APIError{StatusCode: http.StatusInternalServerError},
+			err:  "unexpected status code: 500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.handler)
+			err := c.Do(context.Background(), "GET", "/api/tunnel/web", nil, &APIResponse{})
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v (%T), want an *APIError", err, err)
+			}
+			if !reflect.DeepEqual(apiErr, tt.want) {
+				t.Fatalf("err = %+v, want %+v", apiErr, tt.want)
+			}
+			if err.Error() != tt.err {
+				t.Fatalf("err = %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAPIErrorDecodeData(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		RespondWithErrorData(w, http.StatusBadRequest, "1 of 2 operations failed, no changes were applied", &BatchResponse{Results: []BatchResult{
+			{Action: "create", Name: "db"},
+			{Action: "rename", Name: "web", Error: "invalid action"},
+		}})
+	})
+
+	_, err := c.Batch(context.Background(), &BatchRequest{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an *APIError", err)
+	}
+	res := &BatchResponse{}
+	if err := apiErr.DecodeData(res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Results) != 2 || res.Results[1].Error != "invalid action" {
+		t.Fatalf("results = %+v", res.Results)
+	}
+
+	if err := (&APIError{StatusCode: http.StatusNotFound}).DecodeData(res); err == nil {
+		t.Fatal("decoded an error without data")
+	}
+}
+
+func TestDoInvalidResponse(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>not the tfarm api</html>"))
+	})
+
+	err := c.Do(context.Background(), "GET", "/api/tunnel/web", nil, &APIResponse{})
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to decode response with status code 200") {
+		t.Fatalf("err = %v, want a decode error", err)
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		t.Fatal("a 200 response was returned as an *APIError")
+	}
+}
+
+// TestCreateRequestProxyID makes sure the proxy id tfarmd assigns can't be
+// set or leaked through json.
+func TestCreateRequestProxyID(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"name":"web","ProxyID":"mine"}`))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&CreateRequest{}); err == nil {
+		t.Fatal("decoded a request that sets the proxy id")
+	}
+
+	b, err := json.Marshal(&CreateRequest{Name: "web", ProxyID: "assigned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "assigned") {
+		t.Fatalf("the proxy id was encoded: %s", b)
+	}
+}
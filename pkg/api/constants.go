@@ -1,6 +1,10 @@
 package api
 
+import "time"
+
 const (
-	DefaultPort     = 8700
-	DefaultEndpoint = "https://localhost:8700"
+	DefaultPort        = 8700
+	DefaultEndpoint    = "https://localhost:8700"
+	DefaultTimeout     = 30 * time.Second
+	DefaultWaitTimeout = 60 * time.Second
 )
@@ -0,0 +1,32 @@
+package api
+
+import "time"
+
+// Event types published by tfarmd.
+const (
+	EventFrpcStarted           = "frpc.started"
+	EventFrpcExited            = "frpc.exited"
+	EventFrpcStopped           = "frpc.stopped"
+	EventFrpcRestarting        = "frpc.restarting"
+	EventFrpcDegraded          = "frpc.degraded"
+	EventTunnelCreated         = "tunnel.created"
+	EventTunnelUpdated         = "tunnel.updated"
+	EventTunnelDeleted         = "tunnel.deleted"
+	EventTunnelStatus          = "tunnel.status"
+	EventTunnelRemoved         = "tunnel.removed" // the proxy is no longer reported by frpc
+	EventCredentialsConfigured = "credentials.configured"
+)
+
+// Event is a change to frpc or a tunnel.
+type Event struct {
+	ID      uint64    `json:"id" yaml:"id"`
+	Time    time.Time `json:"time" yaml:"time"`
+	Type    string    `json:"type" yaml:"type"`
+	Tunnel  string    `json:"tunnel,omitempty" yaml:"tunnel,omitempty"`
+	Status  string    `json:"status,omitempty" yaml:"status,omitempty"` // tunnel.status only
+	Message string    `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+type EventsResponse struct {
+	Events []Event `json:"events" yaml:"events"`
+}
@@ -0,0 +1,34 @@
+package api
+
+import "time"
+
+// States of the frpc supervisor.
+const (
+	FrpcStateWaiting = "waiting" // for credentials
+	FrpcStateRunning = "running"
+	FrpcStateBackoff = "backoff" // waiting to start frpc again after it exited
+	FrpcStateStopped = "stopped"
+)
+
+// FrpcExit is a time frpc exited unexpectedly or failed to start.
+type FrpcExit struct {
+	Time time.Time `json:"time" yaml:"time"`
+	// ExitCode is -1 if frpc was killed by a signal or never started.
+	ExitCode      int      `json:"exit_code" yaml:"exit_code"`
+	Error         string   `json:"error" yaml:"error"`
+	UptimeSeconds float64  `json:"uptime_seconds" yaml:"uptime_seconds"`
+	Output        []string `json:"output,omitempty" yaml:"output,omitempty"` // last lines frpc wrote
+}
+
+// FrpcStatus is the state of the frpc process and its supervisor. frpc is
+// degraded when it keeps exiting without staying up.
+type FrpcStatus struct {
+	State               string     `json:"state" yaml:"state"`
+	Degraded            bool       `json:"degraded" yaml:"degraded"`
+	PID                 int        `json:"pid,omitempty" yaml:"pid,omitempty"`
+	StartedAt           *time.Time `json:"started_at,omitempty" yaml:"started_at,omitempty"`
+	Starts              int        `json:"starts" yaml:"starts"`
+	ConsecutiveFailures int        `json:"consecutive_failures" yaml:"consecutive_failures"`
+	NextStart           *time.Time `json:"next_start,omitempty" yaml:"next_start,omitempty"`
+	Exits               []FrpcExit `json:"exits" yaml:"exits"` // oldest first
+}
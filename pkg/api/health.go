@@ -0,0 +1,14 @@
+package api
+
+// HealthResponse is the result of a health or readiness probe.
+type HealthResponse struct {
+	Status string        `json:"status"` // ok or unavailable
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the status of one component tfarmd depends on.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
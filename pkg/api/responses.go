@@ -2,34 +2,55 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
+// APIVersion identifies the shape of the response envelope.
+const APIVersion = "v1"
+
 type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
-	Error   string `json:"error,omitempty"`
+	APIVersion string      `json:"api_version"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
 }
 
 func RespondWithSuccess(w http.ResponseWriter, message string) {
+	RespondWithData(w, message, nil)
+}
+
+func RespondWithData(w http.ResponseWriter, message string, data interface{}) {
 	response := &Response{
-		Success: true,
-		Message: message,
+		APIVersion: APIVersion,
+		Success:    true,
+		Message:    message,
+		Data:       data,
 	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("failed to write HTTP response: %s", err)
+		slog.Error("failed to write HTTP response", "err", err)
 	}
 }
 
 func RespondWithError(w http.ResponseWriter, status int, errMsg string) {
+	RespondWithErrorData(w, status, errMsg, nil)
+}
+
+// RespondWithErrorData responds with an error along with data describing
+// the failure, such as per-item results of a batch.
+func RespondWithErrorData(w http.ResponseWriter, status int, errMsg string, data interface{}) {
 	response := &Response{
-		Success: false,
-		Error:   errMsg,
+		APIVersion: APIVersion,
+		Success:    false,
+		Error:      errMsg,
+		Data:       data,
 	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("failed to write HTTP response: %s", err)
+		slog.Error("failed to write HTTP response", "err", err)
 	}
 }
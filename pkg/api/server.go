@@ -1,17 +1,23 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 )
 
 type APIServer struct {
-	server  *http.Server
-	port    int
+	server *http.Server
+	port   int
+	// ErrChan is buffered for the server's one listener, so its error is
+	// never stuck unsent when tfarmd is already shutting down for
+	// another reason.
 	ErrChan chan error
 }
 
@@ -45,16 +51,57 @@ func NewServer(handler http.Handler, port int, tlsFiles *TLSFiles) (*APIServer,
 		Handler:   handler,
 		TLSConfig: tlsConfig,
 	}
+	return newAPIServer(server, port), nil
+}
+
+// NewInsecureServer returns a server listening on addr without TLS or
+// client authentication, for endpoints such as metrics and health probes
+// that are used by other tools.
+func NewInsecureServer(handler http.Handler, addr string) *APIServer {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	return newAPIServer(server, 0)
+}
+
+// newAPIServer cancels the context of in-flight requests when the server
+// is shut down, so event streams and waits end instead of holding up the
+// shutdown until its timeout.
+func newAPIServer(server *http.Server, port int) *APIServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	server.BaseContext = func(net.Listener) context.Context {
+		return ctx
+	}
+	server.RegisterOnShutdown(cancel)
+
 	return &APIServer{
 		server:  server,
 		port:    port,
-		ErrChan: make(chan error),
-	}, nil
+		ErrChan: make(chan error, 1),
+	}
 }
 
+// Start serves in the background. Errors other than the server being shut
+// down are sent on ErrChan.
 func (a *APIServer) Start() {
 	go func() {
-		log.Printf("api server listening on %s", a.server.Addr)
-		a.ErrChan <- a.server.ListenAndServeTLS("", "")
+		var err error
+		if a.server.TLSConfig == nil {
+			slog.Info("server listening without tls", "addr", a.server.Addr)
+			err = a.server.ListenAndServe()
+		} else {
+			slog.Info("api server listening", "addr", a.server.Addr)
+			err = a.server.ListenAndServeTLS("", "")
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			a.ErrChan <- err
+		}
 	}()
 }
+
+// Shutdown stops accepting connections and waits for in-flight requests
+// to finish, or for ctx to be done.
+func (a *APIServer) Shutdown(ctx context.Context) error {
+	return a.server.Shutdown(ctx)
+}
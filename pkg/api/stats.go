@@ -0,0 +1,23 @@
+package api
+
+import "time"
+
+// TrafficSample is a tunnel's traffic as reported by the frps dashboard at
+// one point in time. Traffic counts bytes since the start of the day on
+// frps, so it drops back to zero at midnight server time.
+type TrafficSample struct {
+	Time       time.Time `json:"time" yaml:"time"`
+	TrafficIn  int64     `json:"traffic_in" yaml:"traffic_in"`
+	TrafficOut int64     `json:"traffic_out" yaml:"traffic_out"`
+	Conns      int64     `json:"conns" yaml:"conns"`
+}
+
+// TunnelStats is the recent traffic of a tunnel, oldest sample first.
+type TunnelStats struct {
+	Name    string          `json:"name" yaml:"name"`
+	Samples []TrafficSample `json:"samples" yaml:"samples"`
+}
+
+type StatsResponse struct {
+	Tunnels []TunnelStats `json:"tunnels" yaml:"tunnels"`
+}
@@ -0,0 +1,56 @@
+package api
+
+import "time"
+
+// Tunnel describes a configured tunnel merged with its live proxy status.
+type Tunnel struct {
+	Name       string  `json:"name" yaml:"name"`
+	Type       string  `json:"type" yaml:"type"`
+	LocalIP    string  `json:"local_ip" yaml:"local_ip"`
+	LocalPort  int     `json:"local_port" yaml:"local_port"`
+	RemotePort int     `json:"remote_port,omitempty" yaml:"remote_port,omitempty"`
+	ProxyID    string  `json:"proxy_id" yaml:"proxy_id"`
+	Status     string  `json:"status" yaml:"status"`
+	RemoteURL  string  `json:"remote_url,omitempty" yaml:"remote_url,omitempty"`
+	Error      string  `json:"error,omitempty" yaml:"error,omitempty"`
+	Plugin     *Plugin `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+
+	// metadata recorded by tfarmd, unset for tunnels created before it was recorded
+	CreatedAt   *time.Time        `json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	UpdatedAt   *time.Time        `json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
+	Creator     string            `json:"creator,omitempty" yaml:"creator,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	Lease       string            `json:"lease,omitempty" yaml:"lease,omitempty"`
+
+	// traffic options
+	BandwidthLimit      string `json:"bandwidth_limit,omitempty" yaml:"bandwidth_limit,omitempty"`
+	UseEncryption       bool   `json:"use_encryption,omitempty" yaml:"use_encryption,omitempty"`
+	UseCompression      bool   `json:"use_compression,omitempty" yaml:"use_compression,omitempty"`
+	HealthCheckType     string `json:"health_check_type,omitempty" yaml:"health_check_type,omitempty"`
+	HealthCheckURL      string `json:"health_check_url,omitempty" yaml:"health_check_url,omitempty"`
+	HealthCheckInterval int    `json:"health_check_interval_s,omitempty" yaml:"health_check_interval_s,omitempty"`
+	Group               string `json:"group,omitempty" yaml:"group,omitempty"`
+
+	// http and https fields
+	CustomDomains     []string          `json:"custom_domains,omitempty" yaml:"custom_domains,omitempty"`
+	Locations         []string          `json:"locations,omitempty" yaml:"locations,omitempty"`
+	HostHeaderRewrite string            `json:"host_header_rewrite,omitempty" yaml:"host_header_rewrite,omitempty"`
+	HTTPUser          string            `json:"http_user,omitempty" yaml:"http_user,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+
+	// secret tunnel (stcp, xtcp, sudp) fields
+	AllowUsers []string `json:"allow_users,omitempty" yaml:"allow_users,omitempty"`
+
+	// visitor fields
+	Role       string `json:"role,omitempty" yaml:"role,omitempty"`
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	ServerUser string `json:"server_user,omitempty" yaml:"server_user,omitempty"`
+	BindAddr   string `json:"bind_addr,omitempty" yaml:"bind_addr,omitempty"`
+	BindPort   int    `json:"bind_port,omitempty" yaml:"bind_port,omitempty"`
+}
+
+type TunnelsResponse struct {
+	Tunnels []Tunnel `json:"tunnels" yaml:"tunnels"`
+}
@@ -7,10 +7,13 @@ import (
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var isConfigured bool
+// isConfigured is read without isConfiguredMu, which is held while
+// waiting for credentials.
+var isConfigured atomic.Bool
 var isConfiguredMu sync.Mutex
 
 type ConfigureCredentials struct {
@@ -22,7 +25,7 @@ type ConfigureCredentials struct {
 }
 
 func IsConfigured() bool {
-	return isConfigured
+	return isConfigured.Load()
 }
 
 func WaitForCredentials(workDir string) (*ConfigureCredentials, error) {
@@ -35,7 +38,7 @@ func WaitForCredentials(workDir string) (*ConfigureCredentials, error) {
 			return nil, fmt.Errorf("error checking for credentials.json: %s", err)
 		}
 
-		isConfigured = false
+		isConfigured.Store(false)
 		log.Println("waiting for credentials.json to be created")
 		for {
 			if _, err := os.Stat(credsPath); err != nil {
@@ -49,7 +52,7 @@ func WaitForCredentials(workDir string) (*ConfigureCredentials, error) {
 		}
 		log.Println("credentials.json created")
 	}
-	isConfigured = true
+	isConfigured.Store(true)
 
 	b, err := os.ReadFile(credsPath)
 	if err != nil {
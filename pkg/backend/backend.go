@@ -0,0 +1,151 @@
+// Package backend is what the tfarmd api runs tunnels with. The api only
+// talks to the tunnel engine through Backend, so it can run against frpc,
+// an in-memory fake, or another engine.
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/auth"
+	"github.com/cbodonnell/tfarm/pkg/events"
+)
+
+var (
+	// ErrVerify is wrapped by errors from ApplyTunnels when the engine
+	// rejected the changed tunnel configs.
+	ErrVerify = errors.New("failed to verify")
+	// ErrReload is wrapped by errors from ApplyTunnels when the engine
+	// failed to pick up the changed tunnel configs.
+	ErrReload = errors.New("failed to reload")
+)
+
+// Tunnel is a tunnel as the api describes it. Exactly one of Proxy or
+// Visitor is set: a proxy exposes a local service, a visitor connects to
+// another client's secret tunnel. How the tunnel is configured in the
+// engine is up to the backend.
+type Tunnel struct {
+	Proxy   *api.CreateRequest
+	Visitor *api.VisitorRequest
+}
+
+// IsVisitor reports whether the tunnel is a visitor.
+func (t *Tunnel) IsVisitor() bool {
+	return t.Visitor != nil
+}
+
+// Type returns the tunnel type, e.g. http or stcp.
+func (t *Tunnel) Type() string {
+	if t.IsVisitor() {
+		return t.Visitor.Type
+	}
+	return t.Proxy.Type
+}
+
+// Equal reports whether both tunnels configure the engine the same way.
+// Metadata such as labels and expiry is not part of the config and is
+// ignored.
+func (t *Tunnel) Equal(o *Tunnel) bool {
+	if t.IsVisitor() || o.IsVisitor() {
+		if !t.IsVisitor() || !o.IsVisitor() {
+			return false
+		}
+		a, b := *t.Visitor, *o.Visitor
+		a.Labels, a.Description = nil, ""
+		b.Labels, b.Description = nil, ""
+		return reflect.DeepEqual(a, b)
+	}
+	return reflect.DeepEqual(proxyConfig(t.Proxy), proxyConfig(o.Proxy))
+}
+
+// proxyConfig returns a copy of req without its metadata, with empty lists
+// and maps set to nil so they compare equal.
+func proxyConfig(req *api.CreateRequest) api.CreateRequest {
+	c := *req
+	c.Labels, c.Description = nil, ""
+	c.TTL, c.ExpiresAt, c.Lease = "", nil, ""
+	if len(c.AllowUsers) == 0 {
+		c.AllowUsers = nil
+	}
+	if len(c.CustomDomains) == 0 {
+		c.CustomDomains = nil
+	}
+	if len(c.Locations) == 0 {
+		c.Locations = nil
+	}
+	if len(c.Headers) == 0 {
+		c.Headers = nil
+	}
+	if c.Plugin != nil && len(c.Plugin.Params) == 0 {
+		c.Plugin = &api.Plugin{Name: c.Plugin.Name}
+	}
+	return c
+}
+
+// TunnelChanges maps tunnel names to their new tunnel, or to nil to delete
+// the tunnel.
+type TunnelChanges map[string]*Tunnel
+
+// Stats is what the engine has been up to, for metrics and health checks.
+type Stats struct {
+	Starts          int
+	StartedAt       time.Time      // zero while the engine is not running
+	LastLogin       time.Time      // last successful login to the tunnel server
+	LastDisconnect  time.Time      // last time the engine lost its connection to the tunnel server
+	CommandFailures map[string]int // keyed by command, e.g. verify
+}
+
+// Backend runs tunnels.
+//
+// Lock and Unlock serialize changes to the tunnels and the engine, RLock
+// and RUnlock let readers run alongside each other. Callers hold the lock
+// around the methods that read or change tunnels.
+type Backend interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+
+	// Start runs the engine in the background until Stop, starting it
+	// again whenever it exits.
+	Start()
+	// Stop stops the engine for good.
+	Stop() error
+	// Restart stops the engine and starts it again.
+	Restart() error
+	// Running reports whether the engine is up and can take changes.
+	Running() bool
+
+	// Configure saves the credentials for the tunnel server and restarts
+	// the engine with them.
+	Configure(creds *auth.ConfigureCredentials) error
+	// Configured reports whether the engine has credentials.
+	Configured() bool
+
+	// Verify checks the current tunnel configs and returns what the
+	// engine had to say about them, whether or not they are valid.
+	Verify() ([]byte, error)
+	// Reload makes the engine pick up the current tunnel configs.
+	Reload() error
+
+	// Tunnels returns every tunnel, keyed by name.
+	Tunnels() (map[string]*Tunnel, error)
+	// Tunnel returns the named tunnel. If the tunnel does not exist, the
+	// returned error satisfies os.IsNotExist.
+	Tunnel(name string) (*Tunnel, error)
+	// ApplyTunnels verifies and reloads the changes as one: either all of
+	// them take effect or none do.
+	ApplyTunnels(changes TunnelChanges) error
+
+	// Status returns the status of every proxy the engine runs, keyed by
+	// name.
+	Status() (map[string]api.ProxyStatus, error)
+	// Supervisor returns the state of the engine and its recent exits.
+	Supervisor() *api.FrpcStatus
+	// Stats returns what the engine has been up to, for metrics.
+	Stats() Stats
+	// Events is where engine and tunnel events are published.
+	Events() *events.Bus
+}
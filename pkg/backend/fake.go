@@ -0,0 +1,342 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/auth"
+	"github.com/cbodonnell/tfarm/pkg/events"
+)
+
+var errFakeNotRunning = errors.New("fake backend not running")
+
+// Fake is an in-memory backend for testing the api without frpc. Every
+// proxy is reported running unless SetStatus or FailStatus says otherwise,
+// and FailVerify and FailReload make the engine reject changes.
+type Fake struct {
+	// the config lock callers hold, separate from mu so the fake can be
+	// inspected while it is held
+	configMu sync.RWMutex
+
+	mu             sync.Mutex
+	configured     bool
+	running        bool
+	stopped        bool
+	starts         int
+	startedAt      time.Time
+	lastLogin      time.Time
+	lastDisconnect time.Time
+	tunnels        map[string]*Tunnel
+	statuses       map[string]api.ProxyStatus
+	verifyErr      error
+	reloadErr      error
+	statusErr      error
+	failures       map[string]int
+	events         *events.Bus
+}
+
+// NewFake returns a fake that is not configured. Configure it, or pass
+// configured to have it start running as soon as Start is called.
+func NewFake(configured bool) *Fake {
+	return &Fake{
+		configured: configured,
+		tunnels:    make(map[string]*Tunnel),
+		statuses:   make(map[string]api.ProxyStatus),
+		failures:   make(map[string]int),
+		events:     events.NewBus(),
+	}
+}
+
+func (b *Fake) Lock()    { b.configMu.Lock() }
+func (b *Fake) Unlock()  { b.configMu.Unlock() }
+func (b *Fake) RLock()   { b.configMu.RLock() }
+func (b *Fake) RUnlock() { b.configMu.RUnlock() }
+
+func (b *Fake) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.start()
+}
+
+// start runs the fake if it is configured and not stopped. The caller must
+// hold mu.
+func (b *Fake) start() {
+	if !b.configured || b.stopped {
+		return
+	}
+	b.running = true
+	b.starts++
+	b.startedAt = time.Now()
+	// the fake logs in as soon as it starts
+	b.lastLogin = b.startedAt
+}
+
+func (b *Fake) Stop() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = false
+	b.stopped = true
+	return nil
+}
+
+func (b *Fake) Restart() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return errors.New("fake backend stopped")
+	}
+	b.running = false
+	b.start()
+	return nil
+}
+
+func (b *Fake) Running() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.running
+}
+
+func (b *Fake) Configure(creds *auth.ConfigureCredentials) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.configured = true
+	b.running = false
+	b.start()
+	return nil
+}
+
+func (b *Fake) Configured() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.configured
+}
+
+// FailVerify makes verifying fail with err from now on, or succeed again
+// if err is nil.
+func (b *Fake) FailVerify(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.verifyErr = err
+}
+
+// FailReload makes reloading fail with err from now on, or succeed again
+// if err is nil.
+func (b *Fake) FailReload(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reloadErr = err
+}
+
+// FailStatus makes reading the proxy statuses fail with err from now on,
+// or succeed again if err is nil.
+func (b *Fake) FailStatus(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.statusErr = err
+}
+
+// Disconnect makes the fake lose its connection to the tunnel server until
+// it is restarted.
+func (b *Fake) Disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastDisconnect = time.Now()
+}
+
+// SetStatus overrides the status reported for the named proxy.
+func (b *Fake) SetStatus(name string, status api.ProxyStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status.Name = name
+	b.statuses[name] = status
+}
+
+func (b *Fake) Verify() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.verify(b.tunnels); err != nil {
+		return []byte(err.Error() + "\n"), err
+	}
+	return []byte("fake: the tunnel configs are ok\n"), nil
+}
+
+// verify checks that every tunnel is either a proxy or a visitor. The
+// caller must hold mu.
+func (b *Fake) verify(tunnels map[string]*Tunnel) error {
+	err := b.verifyErr
+	if err == nil {
+		for name, t := range tunnels {
+			if (t.Proxy == nil) == (t.Visitor == nil) {
+				err = fmt.Errorf("tunnel %s must be either a proxy or a visitor", name)
+				break
+			}
+		}
+	}
+	if err != nil {
+		b.failures["verify"]++
+	}
+	return err
+}
+
+func (b *Fake) Reload() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reload()
+}
+
+// reload fails if the fake isn't running. The caller must hold mu.
+func (b *Fake) reload() error {
+	err := b.reloadErr
+	if err == nil && !b.running {
+		err = errFakeNotRunning
+	}
+	if err != nil {
+		b.failures["reload"]++
+	}
+	return err
+}
+
+func (b *Fake) Tunnels() (map[string]*Tunnel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tunnels := make(map[string]*Tunnel, len(b.tunnels))
+	for name, t := range b.tunnels {
+		tunnels[name] = copyTunnel(t)
+	}
+	return tunnels, nil
+}
+
+func (b *Fake) Tunnel(name string) (*Tunnel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.tunnels[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return copyTunnel(t), nil
+}
+
+// copyTunnel copies t so callers can't change the fake's tunnels.
+func copyTunnel(t *Tunnel) *Tunnel {
+	c := &Tunnel{}
+	if t.Proxy != nil {
+		proxy := *t.Proxy
+		c.Proxy = &proxy
+	}
+	if t.Visitor != nil {
+		visitor := *t.Visitor
+		c.Visitor = &visitor
+	}
+	return c
+}
+
+func (b *Fake) ApplyTunnels(changes TunnelChanges) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tunnels := make(map[string]*Tunnel, len(b.tunnels))
+	for name, t := range b.tunnels {
+		tunnels[name] = t
+	}
+	for name, t := range changes {
+		if t != nil {
+			tunnels[name] = copyTunnel(t)
+			continue
+		}
+		if _, ok := tunnels[name]; !ok {
+			return fmt.Errorf("failed to delete tunnel config %s: %w", name, fs.ErrNotExist)
+		}
+		delete(tunnels, name)
+	}
+
+	if err := b.verify(tunnels); err != nil {
+		return fmt.Errorf("%w: %s", ErrVerify, err)
+	}
+	if err := b.reload(); err != nil {
+		return fmt.Errorf("%w: %s", ErrReload, err)
+	}
+
+	b.tunnels = tunnels
+	return nil
+}
+
+func (b *Fake) Status() (map[string]api.ProxyStatus, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running {
+		return nil, errFakeNotRunning
+	}
+	if b.statusErr != nil {
+		return nil, b.statusErr
+	}
+
+	statuses := make(map[string]api.ProxyStatus)
+	for name, t := range b.tunnels {
+		if t.IsVisitor() {
+			// visitors don't have a proxy
+			continue
+		}
+		if status, ok := b.statuses[name]; ok {
+			statuses[name] = status
+			continue
+		}
+		status := api.ProxyStatus{Name: name, Type: t.Proxy.Type, Status: "running"}
+		if t.Proxy.Plugin != nil {
+			status.Plugin = t.Proxy.Plugin.Name
+		} else {
+			status.LocalAddr = net.JoinHostPort(t.Proxy.LocalIP, strconv.Itoa(t.Proxy.LocalPort))
+		}
+		statuses[name] = status
+	}
+
+	return statuses, nil
+}
+
+func (b *Fake) Supervisor() *api.FrpcStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := &api.FrpcStatus{Starts: b.starts, Exits: []api.FrpcExit{}}
+	switch {
+	case b.running:
+		st.State = api.FrpcStateRunning
+		startedAt := b.startedAt
+		st.StartedAt = &startedAt
+	case b.stopped:
+		st.State = api.FrpcStateStopped
+	default:
+		st.State = api.FrpcStateWaiting
+	}
+
+	return st
+}
+
+func (b *Fake) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := Stats{
+		Starts:          b.starts,
+		LastLogin:       b.lastLogin,
+		LastDisconnect:  b.lastDisconnect,
+		CommandFailures: make(map[string]int),
+	}
+	if b.running {
+		stats.StartedAt = b.startedAt
+	}
+	for cmd, n := range b.failures {
+		stats.CommandFailures[cmd] = n
+	}
+
+	return stats
+}
+
+func (b *Fake) Events() *events.Bus {
+	return b.events
+}
@@ -0,0 +1,212 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/auth"
+	"github.com/cbodonnell/tfarm/pkg/events"
+	"github.com/cbodonnell/tfarm/pkg/frpc"
+)
+
+// Frpc is the backend that runs tunnels with frpc, either the binary or
+// embedded. Tunnels are rendered to frp ini files in conf.d.
+type Frpc struct {
+	f *frpc.Frpc
+}
+
+func NewFrpc(f *frpc.Frpc) *Frpc {
+	return &Frpc{f: f}
+}
+
+func (b *Frpc) Lock()    { b.f.LockConfig() }
+func (b *Frpc) Unlock()  { b.f.UnlockConfig() }
+func (b *Frpc) RLock()   { b.f.RLockConfig() }
+func (b *Frpc) RUnlock() { b.f.RUnlockConfig() }
+
+func (b *Frpc) Start() {
+	b.f.StartLoop()
+}
+
+func (b *Frpc) Stop() error {
+	return b.f.Shutdown()
+}
+
+func (b *Frpc) Restart() error {
+	return b.f.Restart()
+}
+
+func (b *Frpc) Running() bool {
+	return b.f.IsCmd()
+}
+
+// Configure writes credentials.json, which the supervisor waits for, and
+// restarts frpc with the signed config.
+func (b *Frpc) Configure(creds *auth.ConfigureCredentials) error {
+	credsJSON, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %s", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(b.f.WorkDir, "credentials.json"), credsJSON, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials: %s", err)
+	}
+
+	if err := b.f.SignConfig(creds); err != nil {
+		return fmt.Errorf("failed to sign frpc config: %s", err)
+	}
+
+	if err := b.f.Restart(); err != nil {
+		return fmt.Errorf("failed to restart frpc: %s", err)
+	}
+
+	return nil
+}
+
+func (b *Frpc) Configured() bool {
+	return auth.IsConfigured()
+}
+
+func (b *Frpc) Verify() ([]byte, error) {
+	return b.f.Output("verify")
+}
+
+func (b *Frpc) Reload() error {
+	_, err := b.f.Output("reload")
+	return err
+}
+
+// Tunnels parses the tunnel config files in conf.d.
+func (b *Frpc) Tunnels() (map[string]*Tunnel, error) {
+	paths, err := filepath.Glob(b.f.TunnelConfigPath("*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tunnel config files: %s", err)
+	}
+
+	tunnels := make(map[string]*Tunnel)
+	for _, p := range paths {
+		name := strings.TrimSuffix(filepath.Base(p), ".ini")
+		t, err := b.Tunnel(name)
+		if err != nil {
+			return nil, err
+		}
+		tunnels[name] = t
+	}
+
+	return tunnels, nil
+}
+
+func (b *Frpc) Tunnel(name string) (*Tunnel, error) {
+	config, err := os.ReadFile(b.f.TunnelConfigPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return parseTunnel(name, config)
+}
+
+// parseTunnel parses the frp config file of a tunnel.
+func parseTunnel(name string, config []byte) (*Tunnel, error) {
+	conf, err := frpc.ParseTunnelConfig(name, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Tunnel{Proxy: conf.CreateRequest(name), Visitor: conf.VisitorRequest(name)}, nil
+}
+
+// renderTunnel renders the frp config file of a tunnel.
+func renderTunnel(t *Tunnel) ([]byte, error) {
+	if t.IsVisitor() {
+		return frpc.RenderVisitorConfig(t.Visitor)
+	}
+	return frpc.RenderTunnelConfig(t.Proxy)
+}
+
+// ApplyTunnels makes the changes to a staged copy of conf.d, verifies it
+// and swaps it in.
+func (b *Frpc) ApplyTunnels(changes TunnelChanges) error {
+	staging, err := b.f.NewStaging()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := staging.Close(); err != nil {
+			slog.Error("failed to remove staging dir", "err", err)
+		}
+	}()
+
+	for name, t := range changes {
+		p := staging.TunnelConfigPath(name)
+		if t == nil {
+			if err := os.Remove(p); err != nil {
+				return fmt.Errorf("failed to delete tunnel config %s: %w", name, err)
+			}
+			continue
+		}
+		config, err := renderTunnel(t)
+		if err != nil {
+			return fmt.Errorf("%w: tunnel %s: %s", ErrVerify, name, err)
+		}
+		if err := os.WriteFile(p, config, 0600); err != nil {
+			return fmt.Errorf("failed to write tunnel config %s: %w", name, err)
+		}
+	}
+
+	if _, err := staging.Verify(); err != nil {
+		return fmt.Errorf("%w: %s", ErrVerify, err)
+	}
+
+	if err := staging.Commit(); err != nil {
+		return fmt.Errorf("%w: %s", ErrReload, err)
+	}
+
+	return nil
+}
+
+// Status queries the frpc admin api.
+func (b *Frpc) Status() (map[string]api.ProxyStatus, error) {
+	res, err := b.f.ProxyStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]api.ProxyStatus)
+	for _, v := range res {
+		for _, ps := range v {
+			statuses[ps.Name] = api.ProxyStatus{
+				Name:       ps.Name,
+				Type:       ps.Type,
+				Status:     ps.Status,
+				LocalAddr:  ps.LocalAddr,
+				Plugin:     ps.Plugin,
+				RemoteAddr: ps.RemoteAddr,
+				Error:      ps.Err,
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
+func (b *Frpc) Supervisor() *api.FrpcStatus {
+	return b.f.Supervisor()
+}
+
+func (b *Frpc) Stats() Stats {
+	stats := b.f.Stats()
+	return Stats{
+		Starts:          stats.Starts,
+		StartedAt:       stats.StartedAt,
+		LastLogin:       stats.LastLogin,
+		LastDisconnect:  stats.LastDisconnect,
+		CommandFailures: stats.CommandFailures,
+	}
+}
+
+func (b *Frpc) Events() *events.Bus {
+	return b.f.Events
+}
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+)
+
+// TestFrpcTunnelRoundTrip makes sure a tunnel reads back from its frp
+// config as it was written, so apply sees it as unchanged.
+func TestFrpcTunnelRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		tunnel *Tunnel
+	}{
+		{name: "http", tunnel: &Tunnel{Proxy: &api.CreateRequest{
+			Name: "web", Type: "http", LocalIP: "127.0.0.1", LocalPort: 8080,
+			CustomDomains: []string{"example.com", "www.example.com"}, Locations: []string{"/", "/api"},
+			HostHeaderRewrite: "internal", HTTPUser: "user", HTTPPwd: "secret",
+			Headers:         map[string]string{"X-From-Where": "tfarm"},
+			HealthCheckType: "http", HealthCheckURL: "/healthz", HealthCheckInterval: 10,
+			BandwidthLimit: "1MB", UseEncryption: true, UseCompression: true,
+			Group: "web", GroupKey: "key", ProxyID: "1",
+		}}},
+		{name: "https", tunnel: &Tunnel{Proxy: &api.CreateRequest{
+			Name: "secure", Type: "https", LocalIP: "127.0.0.1", LocalPort: 8443,
+			CustomDomains: []string{"example.com"}, ProxyID: "2",
+		}}},
+		{name: "tcp", tunnel: &Tunnel{Proxy: &api.CreateRequest{
+			Name: "db", Type: "tcp", LocalIP: "127.0.0.1", LocalPort: 5432, RemotePort: 15432,
+			HealthCheckType: "tcp", ProxyID: "3",
+		}}},
+		{name: "udp", tunnel: &Tunnel{Proxy: &api.CreateRequest{
+			Name: "dns", Type: "udp", LocalIP: "127.0.0.1", LocalPort: 53, RemotePort: 1053, ProxyID: "4",
+		}}},
+		{name: "stcp", tunnel: &Tunnel{Proxy: &api.CreateRequest{
+			Name: "ssh", Type: "stcp", LocalIP: "127.0.0.1", LocalPort: 22,
+			SecretKey: "secret", AllowUsers: []string{"alice", "bob"}, ProxyID: "5",
+		}}},
+		{name: "plugin", tunnel: &Tunnel{Proxy: &api.CreateRequest{
+			Name: "files", Type: "http",
+			Plugin:  &api.Plugin{Name: "static_file", Params: map[string]string{"local_path": "/srv", "strip_prefix": "static"}},
+			ProxyID: "6",
+		}}},
+		{name: "visitor", tunnel: &Tunnel{Visitor: &api.VisitorRequest{
+			Name: "ssh-visitor", Type: "stcp", ServerName: "ssh", ServerUser: "alice",
+			SecretKey: "secret", BindAddr: "127.0.0.1", BindPort: 9000,
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var name string
+			if tt.tunnel.IsVisitor() {
+				name = tt.tunnel.Visitor.Name
+			} else {
+				name = tt.tunnel.Proxy.Name
+			}
+			config, err := renderTunnel(tt.tunnel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := parseTunnel(name, config)
+			if err != nil {
+				t.Fatalf("%s\n%s", err, config)
+			}
+			if !parsed.Equal(tt.tunnel) {
+				t.Fatalf("read back\n%+v\n%+v\nfrom\n%s", parsed.Proxy, parsed.Visitor, config)
+			}
+		})
+	}
+}
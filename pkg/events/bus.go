@@ -0,0 +1,94 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+)
+
+// historySize is how many recent events are kept for clients that connect later.
+const historySize = 100
+
+// subscriberBuffer is how many events a subscriber can fall behind before
+// events are dropped for it.
+const subscriberBuffer = 64
+
+// Bus fans events out to subscribers. Publishing never blocks: a
+// subscriber that doesn't keep up misses events.
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []api.Event
+	subscribers map[chan api.Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		nextID:      1,
+		subscribers: make(map[chan api.Event]struct{}),
+	}
+}
+
+// Publish assigns the event an id and time and sends it to all subscribers.
+// A nil bus discards events.
+func (b *Bus) Publish(e api.Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.ID = b.nextID
+	b.nextID++
+	e.Time = time.Now().UTC()
+
+	b.history = append(b.history, e)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events published from now on. The
+// returned function unsubscribes and closes the channel.
+func (b *Bus) Subscribe() (<-chan api.Event, func()) {
+	ch := make(chan api.Event, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Subscribers returns the number of current subscribers.
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// History returns the most recent events, oldest first.
+func (b *Bus) History() []api.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	history := make([]api.Event, len(b.history))
+	copy(history, b.history)
+	return history
+}
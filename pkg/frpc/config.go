@@ -23,6 +23,7 @@ admin_addr = {{ .AdminAddr }}
 admin_port = {{ .AdminPort }}
 includes = ./conf.d/*.ini
 log_level = {{ .LogLevel }}
+disable_log_color = true
 tls_enable = true
 tls_cert_file = ./tls/frps/client.crt
 tls_key_file = ./tls/frps/client.key
@@ -108,11 +109,25 @@ func SaveFrpcCommonConfig(common config.ClientCommonConf, path string) error {
 		return fmt.Errorf("failed to render template: %s", err)
 	}
 
-	// save to file
-	err = os.WriteFile(path, buf.Bytes(), 0600)
+	// save to file, in one go: the admin api settings are read from it
+	// without the config lock
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
 	if err != nil {
 		return fmt.Errorf("failed to write file: %s", err)
 	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %s", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write file: %s", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,274 @@
+package frpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/cbodonnell/tfarm/pkg/logging"
+	"github.com/fatedier/beego/logs"
+	"github.com/fatedier/frp/client"
+	"github.com/fatedier/frp/client/proxy"
+	"github.com/fatedier/frp/pkg/config"
+	frplog "github.com/fatedier/frp/pkg/util/log"
+)
+
+// NewEmbedded is like New, but frpc runs as a client.Service in tfarmd's
+// process instead of as the frpc binary.
+func NewEmbedded(workDir string, cfg config.ClientCommonConf) (*Frpc, error) {
+	return New("", workDir, cfg)
+}
+
+// Embedded reports whether frpc runs in tfarmd's process.
+func (f *Frpc) Embedded() bool {
+	return f.binPath == ""
+}
+
+type serviceProcess struct {
+	svc        *client.Service
+	serverAddr string
+	cancel     context.CancelFunc
+	done       chan error
+}
+
+// startEmbedded runs a client.Service for frpc.ini. fn is called with each
+// line frp logs.
+//
+// frp v0.51 doesn't let go of a service: its first login retries forever,
+// ignoring Close and the context, unless login_fail_exit is set, and its
+// admin api listener is never closed. So the service gives up on a failed
+// login and the supervisor retries it instead, and the service runs
+// without an admin api, its proxy status is read directly.
+func (f *Frpc) startEmbedded(fn func(line string)) (process, error) {
+	cfg, pxyCfgs, visitorCfgs, err := f.parseConfig("frpc.ini")
+	if err != nil {
+		return nil, err
+	}
+	cfg.LoginFailExit = true
+	cfg.AdminPort = 0
+
+	setFrpLogger(cfg.LogLevel, fn)
+
+	svc, err := client.NewService(cfg, pxyCfgs, visitorCfgs, filepath.Join(f.WorkDir, "frpc.ini"))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &serviceProcess{svc: svc, serverAddr: cfg.ServerAddr, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		p.done <- svc.Run(ctx)
+	}()
+
+	return p, nil
+}
+
+func (p *serviceProcess) wait() (int, error) {
+	// Run returns nil only once the service is closed
+	if err := <-p.done; err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// interrupt cancels the service's context, and Run closes the service.
+// Calling Close from here would race with Run setting the service up.
+func (p *serviceProcess) interrupt() error {
+	p.cancel()
+	return nil
+}
+
+// kill can't do more than interrupt: cancelling aborts dialing frps, but
+// a login that is waiting for frps to answer only ends when it times out.
+func (p *serviceProcess) kill() error {
+	return errCannotKill
+}
+
+// status returns the status of the service's proxies like frp's admin api
+// does. frp doesn't export them, so they are read from the control's
+// proxy manager.
+func (p *serviceProcess) status() (client.StatusResp, error) {
+	ctl := p.svc.GetController()
+	if ctl == nil {
+		return nil, errors.New("frpc has not logged in to frps")
+	}
+	pm, err := proxyManager(ctl)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(client.StatusResp)
+	for _, status := range pm.GetAllProxyStatus() {
+		res[status.Type] = append(res[status.Type], client.NewProxyStatusResp(status, p.serverAddr))
+	}
+	return res, nil
+}
+
+var errNoProxyManager = errors.New("frpc has no proxy manager")
+
+// proxyManager reads the control's unexported proxy manager. It is written
+// against frp v0.51.3 and fails, rather than panics, if frp moves it.
+func proxyManager(ctl *client.Control) (*proxy.Manager, error) {
+	field := reflect.ValueOf(ctl).Elem().FieldByName("pm")
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*proxy.Manager)(nil)) {
+		return nil, errors.New("frp's control has no pm *proxy.Manager field, the proxy status can't be read")
+	}
+	pm := reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface().(*proxy.Manager)
+	if pm == nil {
+		return nil, errNoProxyManager
+	}
+	return pm, nil
+}
+
+func (p *serviceProcess) pid() int {
+	return 0
+}
+
+// embeddedOutput is output for embedded frpc: verify parses the config and
+// reload hands the parsed proxies to the running service, without either
+// forking frpc. The output reads like frpc's.
+func (f *Frpc) embeddedOutput(cmd, configFile string) ([]byte, error) {
+	_, pxyCfgs, visitorCfgs, err := f.parseConfig(configFile)
+	if err == nil {
+		switch cmd {
+		case "verify":
+		case "reload":
+			f.procMu.Lock()
+			p, ok := f.proc.(*serviceProcess)
+			f.procMu.Unlock()
+			if !ok {
+				err = errors.New("frpc not running")
+			} else {
+				err = p.svc.ReloadConf(pxyCfgs, visitorCfgs)
+			}
+		default:
+			err = fmt.Errorf("unknown command %s", cmd)
+		}
+	}
+
+	if err != nil {
+		f.statsMu.Lock()
+		f.stats.CommandFailures[cmd]++
+		f.statsMu.Unlock()
+		return []byte(err.Error() + "\n"), fmt.Errorf("failed to execute frpc %s: %s", cmd, err)
+	}
+
+	if cmd == "verify" {
+		return []byte(fmt.Sprintf("frpc: the configuration file %s syntax is ok\n", configFile)), nil
+	}
+	return []byte("reload success\n"), nil
+}
+
+// parseConfig does what config.ParseClientConfig does, except that
+// relative paths in the config file are relative to the work dir, as they
+// are for the frpc binary, rather than to tfarmd's working directory.
+func (f *Frpc) parseConfig(configFile string) (config.ClientCommonConf, map[string]config.ProxyConf, map[string]config.VisitorConf, error) {
+	content, err := config.GetRenderedConfFromFile(filepath.Join(f.WorkDir, configFile))
+	if err != nil {
+		return config.ClientCommonConf{}, nil, nil, err
+	}
+
+	cfg, err := config.UnmarshalClientConfFromIni(content)
+	if err != nil {
+		return config.ClientCommonConf{}, nil, nil, err
+	}
+	for _, p := range []*string{&cfg.TLSCertFile, &cfg.TLSKeyFile, &cfg.TLSTrustedCaFile} {
+		*p = f.workDirPath(*p)
+	}
+	for i := range cfg.IncludeConfigFiles {
+		cfg.IncludeConfigFiles[i] = f.workDirPath(cfg.IncludeConfigFiles[i])
+	}
+	cfg.Complete()
+	if err := cfg.Validate(); err != nil {
+		return config.ClientCommonConf{}, nil, nil, fmt.Errorf("parse config error: %v", err)
+	}
+
+	buf := bytes.NewBuffer(content)
+	buf.WriteString("\n")
+	for _, include := range cfg.IncludeConfigFiles {
+		files, err := os.ReadDir(filepath.Dir(include))
+		if err != nil {
+			return config.ClientCommonConf{}, nil, nil, fmt.Errorf("getIncludeContents error: %v", err)
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			if matched, _ := filepath.Match(filepath.Base(include), fi.Name()); !matched {
+				continue
+			}
+			b, err := config.GetRenderedConfFromFile(filepath.Join(filepath.Dir(include), fi.Name()))
+			if err != nil {
+				return config.ClientCommonConf{}, nil, nil, fmt.Errorf("render extra config %s error: %v", fi.Name(), err)
+			}
+			buf.Write(b)
+			buf.WriteString("\n")
+		}
+	}
+
+	pxyCfgs, visitorCfgs, err := config.LoadAllProxyConfsFromIni(cfg.User, buf.Bytes(), cfg.Start)
+	if err != nil {
+		return config.ClientCommonConf{}, nil, nil, err
+	}
+
+	return cfg, pxyCfgs, visitorCfgs, nil
+}
+
+func (f *Frpc) workDirPath(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(f.WorkDir, p)
+}
+
+// frp logs through a global beego logger. frpLogWriter is a beego log
+// adapter that hands each line to the function of the running service.
+type frpLogWriter struct{}
+
+var (
+	frpLogOnce sync.Once
+	frpLogMu   sync.Mutex
+	frpLogFn   func(line string)
+)
+
+// setFrpLogger sends frp's logs to fn and, like frpc's, to tfarmd's log.
+// The level can only be set the first time, as frp reads it unlocked.
+func setFrpLogger(level string, fn func(line string)) {
+	frpLogMu.Lock()
+	frpLogFn = fn
+	frpLogMu.Unlock()
+
+	frpLogOnce.Do(func() {
+		logs.Register("tfarm", func() logs.Logger { return frpLogWriter{} })
+		frplog.Log.SetLogger("tfarm")
+		frplog.SetLogLevel(level)
+	})
+}
+
+func (frpLogWriter) Init(string) error { return nil }
+
+func (frpLogWriter) WriteMsg(when time.Time, msg string, level int) error {
+	// the same format as frpc's console log
+	line := when.Format("2006/01/02 15:04:05") + " " + msg
+
+	frpLogMu.Lock()
+	fn := frpLogFn
+	frpLogMu.Unlock()
+	if fn != nil {
+		fn(line)
+	}
+	logging.LogFrpcLine(line, "stdout")
+
+	return nil
+}
+
+func (frpLogWriter) Destroy() {}
+
+func (frpLogWriter) Flush() {}
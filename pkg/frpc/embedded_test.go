@@ -0,0 +1,145 @@
+package frpc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/events"
+	"github.com/fatedier/frp/client"
+	"github.com/fatedier/frp/pkg/config"
+	"github.com/fatedier/frp/pkg/msg"
+	"github.com/fatedier/frp/pkg/util/version"
+)
+
+// newEmbeddedTest returns embedded frpc for frps at serverAddr, without
+// tls or credentials.
+func newEmbeddedTest(t *testing.T, serverAddr string) *Frpc {
+	t.Helper()
+	host, port, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewEmbedded(t.TempDir(), config.GetDefaultClientConf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without tls or tcp mux, the login is the first message frps reads
+	ini := fmt.Sprintf("[common]\nserver_addr = %s\nserver_port = %s\ndial_server_timeout = 1\ntls_enable = false\ntcp_mux = false\n", host, port)
+	if err := os.WriteFile(filepath.Join(f.WorkDir, "frpc.ini"), []byte(ini), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f.Events = events.NewBus()
+	return f
+}
+
+// TestEmbeddedLoginFailureExits makes sure the service exits when it
+// can't log in, rather than retrying where Stop can't reach it.
+func TestEmbeddedLoginFailureExits(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	// nothing listens there any more, so dialing frps is refused
+	l.Close()
+
+	f := newEmbeddedTest(t, addr)
+	f.StartAndWait()
+
+	select {
+	case err := <-f.ErrChan:
+		if !strings.Contains(err.Error(), "exited unexpectedly") {
+			t.Fatalf("err = %s, want an unexpected exit", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("frpc kept trying to log in")
+	}
+	if f.IsCmd() {
+		t.Fatal("frpc is still running")
+	}
+}
+
+// TestEmbeddedStopUnreachableFrps stops frpc while it is stuck logging in
+// to an frps that never answers.
+func TestEmbeddedStopUnreachableFrps(t *testing.T) {
+	defer func(stop time.Duration) { stopTimeout = stop }(stopTimeout)
+	stopTimeout = 100 * time.Millisecond
+
+	// reads the login and never answers it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		// once the login is read, frpc is waiting for the response
+		m, err := msg.ReadMsg(conn)
+		if err != nil {
+			t.Errorf("failed to read the login: %s", err)
+			conn.Close()
+			return
+		}
+		if _, ok := m.(*msg.Login); !ok {
+			t.Errorf("frpc sent %T, want a login", m)
+		}
+		accepted <- conn
+	}()
+
+	f := newEmbeddedTest(t, l.Addr().String())
+	f.StartAndWait()
+
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case <-time.After(30 * time.Second):
+		t.Fatal("frpc did not log in to frps")
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- f.Shutdown()
+	}()
+	select {
+	case err := <-stopped:
+		if err == nil || !strings.Contains(err.Error(), "abandoned it") {
+			t.Fatalf("err = %v, want the run to be abandoned", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on frpc")
+	}
+	if f.IsCmd() {
+		t.Fatal("frpc is still running")
+	}
+
+	// once frps hangs up, the abandoned run exits without being reported
+	conn.Close()
+	select {
+	case err := <-f.ErrChan:
+		t.Fatalf("unexpected exit reported: %s", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+// TestProxyManagerField pins the embedded proxy status to frp v0.51.3,
+// whose control keeps its proxy manager in the unexported pm field. If
+// frp is upgraded, check that the field is still there and still holds
+// every proxy before changing the version here.
+func TestProxyManagerField(t *testing.T) {
+	if v := version.Full(); v != "0.51.3" {
+		t.Fatalf("frp is %s, proxyManager was written against 0.51.3", v)
+	}
+	if _, err := proxyManager(&client.Control{}); err != errNoProxyManager {
+		t.Fatalf("err = %v, want %v", err, errNoProxyManager)
+	}
+}
@@ -1,37 +1,67 @@
 package frpc
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/cbodonnell/tfarm/pkg/api"
 	"github.com/cbodonnell/tfarm/pkg/auth"
 	"github.com/cbodonnell/tfarm/pkg/crypto"
-	"github.com/cbodonnell/tfarm/pkg/logging"
+	"github.com/cbodonnell/tfarm/pkg/events"
 	"github.com/fatedier/frp/client"
 	"github.com/fatedier/frp/pkg/config"
-	"github.com/rodaine/table"
 )
 
 type Frpc struct {
-	binPath      string
-	WorkDir      string
-	stdout       io.Writer
-	stderr       io.Writer
-	cmd          *exec.Cmd
-	StartErrChan chan error
+	binPath string // empty when frpc is embedded
+	WorkDir string
+	stdout  io.Writer
+	stderr  io.Writer
+	proc    process
+	// exited is closed once proc has been waited for
+	exited       chan struct{}
 	ErrChan      chan error
-	ExitChan     chan struct{}
 	restarting   bool
+	stopping     bool
+	shutdownChan chan struct{}
+
+	// Backoff is how long StartLoop waits to start frpc again after it
+	// exits.
+	Backoff Backoff
+	sup     supervisor
+
+	// Events receives frpc lifecycle events. It may be nil.
+	Events *events.Bus
+
+	// configMu serializes changes to frpc.ini and conf.d with the frpc
+	// commands that read them.
+	configMu sync.RWMutex
+	// procMu guards proc, restarting and stopping. It is never held while
+	// waiting on the frpc process.
+	procMu sync.Mutex
+
+	statsMu sync.Mutex
+	stats   Stats
+}
+
+// Stats counts what the frpc process has been up to, for metrics.
+type Stats struct {
+	Starts          int
+	StartedAt       time.Time      // zero while frpc is not running
+	LastLogin       time.Time      // last successful login to frps
+	LastDisconnect  time.Time      // last time frpc lost its connection to frps
+	CommandFailures map[string]int // keyed by subcommand, e.g. verify
 }
 
 type ErrCredentialsNotFound struct {
@@ -55,42 +85,44 @@ func New(binPath, workDir string, cfg config.ClientCommonConf) (*Frpc, error) {
 		binPath:      binPath,
 		WorkDir:      workDir,
 		stdout:       os.Stdout,
+		stats:        Stats{CommandFailures: make(map[string]int)},
 		stderr:       os.Stderr,
-		cmd:          nil,
-		StartErrChan: make(chan error),
+		proc:         nil,
 		ErrChan:      make(chan error),
-		ExitChan:     make(chan struct{}),
 		restarting:   false,
+		shutdownChan: make(chan struct{}),
+		Backoff:      DefaultBackoff,
 	}, nil
 }
 
+// LockConfig locks frpc.ini, conf.d and the frpc process for changes.
+func (f *Frpc) LockConfig() {
+	f.configMu.Lock()
+}
+
+func (f *Frpc) UnlockConfig() {
+	f.configMu.Unlock()
+}
+
+// RLockConfig locks frpc.ini and conf.d for reading.
+func (f *Frpc) RLockConfig() {
+	f.configMu.RLock()
+}
+
+func (f *Frpc) RUnlockConfig() {
+	f.configMu.RUnlock()
+}
+
 func (f *Frpc) IsCmd() bool {
-	return f.cmd != nil
+	f.procMu.Lock()
+	defer f.procMu.Unlock()
+	return f.proc != nil
 }
 
-func (f *Frpc) StartLoop() {
-	go func() {
-		restartDelay := 5 * time.Second
-		for {
-			creds, err := auth.WaitForCredentials(f.WorkDir)
-			if err != nil {
-				f.StartErrChan <- fmt.Errorf("error waiting for credentials: %s", err)
-			}
-
-			if err := f.SignConfig(creds); err != nil {
-				f.StartErrChan <- fmt.Errorf("error signing frpc config: %s", err)
-			}
-
-			f.StartAndWait()
-
-			select {
-			case err = <-f.ErrChan:
-				log.Printf("frpc exited: %s", err)
-				log.Printf("restarting frpc in %s", restartDelay.String())
-				time.Sleep(restartDelay)
-			}
-		}
-	}()
+func (f *Frpc) setRestarting(restarting bool) {
+	f.procMu.Lock()
+	defer f.procMu.Unlock()
+	f.restarting = restarting
 }
 
 func (f *Frpc) SignConfig(creds *auth.ConfigureCredentials) error {
@@ -123,102 +155,191 @@ func (f *Frpc) SignConfig(creds *auth.ConfigureCredentials) error {
 }
 
 func (f *Frpc) Start() error {
-	log.Println("starting frpc")
+	slog.Info("starting frpc")
+
+	f.procMu.Lock()
+	defer f.procMu.Unlock()
 
-	if f.cmd != nil {
+	if f.proc != nil {
 		return errors.New("frpc already running")
 	}
+	if f.stopping {
+		return errors.New("frpc is shutting down")
+	}
 
-	f.cmd = exec.Command(f.binPath, "-c", "frpc.ini")
-	f.cmd.Dir = f.WorkDir
-	stdout, _ := f.cmd.StdoutPipe()
-	stderr, _ := f.cmd.StderrPipe()
-	go logging.LogReaderWithPrefix(stdout, "frpc stdout: ")
-	go logging.LogReaderWithPrefix(stderr, "frpc stderr: ")
+	// the supervisor has to be told before frpc can write anything
+	f.sup.started()
+	output := func(line string) {
+		f.sup.recordOutput(line)
+		f.scanConnection(line)
+	}
 
-	if err := f.cmd.Start(); err != nil {
-		f.cmd = nil
+	var proc process
+	var err error
+	if f.Embedded() {
+		proc, err = f.startEmbedded(output)
+	} else {
+		proc, err = f.startExec(output)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to start frpc: %s", err)
 	}
+	f.proc = proc
+	f.exited = make(chan struct{})
 
-	log.Println("frpc started")
+	f.statsMu.Lock()
+	f.stats.Starts++
+	f.stats.StartedAt = time.Now()
+	f.statsMu.Unlock()
+
+	slog.Info("frpc started")
+	f.Events.Publish(api.Event{Type: api.EventFrpcStarted})
 
 	return nil
 }
 
 func (f *Frpc) Wait() error {
-	if f.cmd == nil {
+	f.procMu.Lock()
+	proc := f.proc
+	f.procMu.Unlock()
+
+	if proc == nil {
 		return errors.New("frpc not running")
 	}
 
-	if err := f.cmd.Wait(); err != nil {
-		if f.restarting {
-			return nil
-		}
-		f.cmd = nil
-		return fmt.Errorf("frpc exited unexpectedly: %s", err)
+	exitCode, err := proc.wait()
+
+	f.procMu.Lock()
+	defer f.procMu.Unlock()
+
+	// Stop sent the signal, so the exit is expected whatever the exit code.
+	// If Stop gave up on this run, another may be running by now.
+	if f.restarting || f.stopping || f.proc != proc {
+		return nil
+	}
+
+	f.proc = nil
+	if err != nil {
+		f.Events.Publish(api.Event{Type: api.EventFrpcExited, Message: err.Error()})
+		err = fmt.Errorf("frpc exited unexpectedly: %s", err)
+	} else {
+		f.Events.Publish(api.Event{Type: api.EventFrpcExited})
+		err = errors.New("frpc exited unexpectedly with no error")
 	}
+	f.failed(exitCode, err)
 
-	f.cmd = nil
-	return errors.New("frpc exited unexpectedly with no error")
+	return err
 }
 
 func (f *Frpc) StartAndWait() {
 	go func() {
 		if err := f.Start(); err != nil {
+			f.failed(-1, err)
 			f.ErrChan <- fmt.Errorf("failed to start frpc: %s", err)
 			return
 		}
 
-		if err := f.Wait(); err != nil {
-			f.ErrChan <- fmt.Errorf("frpc exited unexpectedly: %s", err)
-			return
-		}
+		f.procMu.Lock()
+		exited := f.exited
+		f.procMu.Unlock()
 
-		f.ExitChan <- struct{}{}
+		err := f.Wait()
+		close(exited)
+		if err != nil {
+			f.ErrChan <- err
+		}
 	}()
 }
 
+var (
+	// stopTimeout is how long Stop waits for frpc to exit after
+	// interrupting it.
+	stopTimeout = 5 * time.Second
+	// killTimeout is how long Stop waits for frpc to exit after killing
+	// it, before giving up on it.
+	killTimeout = 5 * time.Second
+)
+
 func (f *Frpc) Stop() error {
-	log.Println("stopping frpc")
+	slog.Info("stopping frpc")
+
+	f.procMu.Lock()
+	proc, exited := f.proc, f.exited
+	f.procMu.Unlock()
 
-	if f.cmd == nil {
-		log.Println("warning: frpc is not running, ignoring stop request")
+	if proc == nil {
+		slog.Warn("frpc is not running, ignoring stop request")
 		return nil
 	}
 
-	if err := f.cmd.Process.Signal(os.Interrupt); err != nil {
+	if err := proc.interrupt(); err != nil {
 		return fmt.Errorf("failed to send interrupt signal to frpc: %s", err)
 	}
 
+	var err error
 	select {
-	case <-time.After(5 * time.Second):
-		log.Println("frpc did not exit gracefully after 5 seconds, killing...")
-		f.cmd.Process.Kill()
-		<-f.ExitChan
-		log.Println("frpc killed")
-	case <-f.ExitChan:
-		log.Println("frpc exited gracefully")
+	case <-time.After(stopTimeout):
+		if errors.Is(proc.kill(), errCannotKill) {
+			// forget about it, so frpc can be started again
+			slog.Warn("frpc did not exit gracefully and can't be killed, abandoning it", "timeout", stopTimeout)
+			err = fmt.Errorf("frpc did not exit %s after being interrupted, abandoned it", stopTimeout)
+			break
+		}
+		slog.Warn("frpc did not exit gracefully, killing", "timeout", stopTimeout)
+		select {
+		case <-exited:
+			slog.Info("frpc killed")
+		case <-time.After(killTimeout):
+			// forget about it, so frpc can be started again
+			err = fmt.Errorf("frpc did not exit %s after being killed", killTimeout)
+		}
+	case <-exited:
+		slog.Info("frpc exited gracefully")
 	}
 
-	f.cmd = nil
+	f.procMu.Lock()
+	if f.proc == proc {
+		f.proc = nil
+	}
+	f.procMu.Unlock()
+	f.Events.Publish(api.Event{Type: api.EventFrpcStopped})
 
-	return nil
+	return err
+}
+
+// Shutdown stops frpc for good: StartLoop won't start it again. The caller
+// should hold the config lock so frpc isn't stopped in the middle of a
+// reload.
+func (f *Frpc) Shutdown() error {
+	f.procMu.Lock()
+	if !f.stopping {
+		f.stopping = true
+		close(f.shutdownChan)
+	}
+	f.procMu.Unlock()
+
+	return f.Stop()
 }
 
 func (f *Frpc) Restart() error {
-	log.Println("restarting frpc")
+	slog.Info("restarting frpc")
 
-	if f.cmd == nil {
-		log.Println("warning: frpc is not running, ignoring restart request")
+	if !f.IsCmd() {
+		slog.Warn("frpc is not running, ignoring restart request")
 		return nil
 	}
+	f.Events.Publish(api.Event{Type: api.EventFrpcRestarting})
 
-	f.restarting = true
-	if err := f.Stop(); err != nil {
-		return fmt.Errorf("failed to stop frpc: %s", err)
+	f.setRestarting(true)
+	err := f.Stop()
+	f.setRestarting(false)
+	if err != nil {
+		// Stop gives up on a run that won't exit, and another can start
+		if f.IsCmd() {
+			return fmt.Errorf("failed to stop frpc: %s", err)
+		}
+		slog.Warn("starting frpc again", "err", err)
 	}
-	f.restarting = false
 
 	creds, err := auth.WaitForCredentials(f.WorkDir)
 	if err != nil {
@@ -235,18 +356,91 @@ func (f *Frpc) Restart() error {
 }
 
 func (f *Frpc) Output(cmd string) ([]byte, error) {
-	frpcCmd := exec.Command(f.binPath, cmd, "-c", "frpc.ini")
+	return f.output(cmd, "frpc.ini")
+}
+
+// output runs the frpc subcommand against the given config file, relative
+// to the work dir.
+func (f *Frpc) output(cmd, configFile string) ([]byte, error) {
+	if f.Embedded() {
+		return f.embeddedOutput(cmd, configFile)
+	}
+
+	frpcCmd := exec.Command(f.binPath, cmd, "-c", configFile)
 	frpcCmd.Dir = f.WorkDir
 
 	output, err := frpcCmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute frpc verify: %s", err)
+		f.statsMu.Lock()
+		f.stats.CommandFailures[cmd]++
+		f.statsMu.Unlock()
+		return output, fmt.Errorf("failed to execute frpc %s: %s", cmd, err)
 	}
 
 	return output, nil
 }
 
-func (f *Frpc) Status() ([]byte, error) {
+// Stats returns a copy of the process stats.
+func (f *Frpc) Stats() Stats {
+	// Start takes statsMu while holding procMu, so check first
+	running := f.IsCmd()
+
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+
+	stats := f.stats
+	stats.CommandFailures = make(map[string]int, len(f.stats.CommandFailures))
+	for cmd, n := range f.stats.CommandFailures {
+		stats.CommandFailures[cmd] = n
+	}
+	if !running {
+		stats.StartedAt = time.Time{}
+	}
+
+	return stats
+}
+
+// disconnectLogs are what frpc logs when it loses or can't get a
+// connection to frps.
+var disconnectLogs = []string{
+	"to reconnect", // the connection closed, e.g. frps went away
+	"reconnect to server error",
+	"login to server failed",
+	"heartbeat timeout",
+}
+
+// scanConnection records logins to frps and lost connections from the
+// frpc log.
+func (f *Frpc) scanConnection(line string) {
+	if strings.Contains(line, "login to server success") {
+		f.statsMu.Lock()
+		f.stats.LastLogin = time.Now()
+		f.statsMu.Unlock()
+		return
+	}
+	for _, s := range disconnectLogs {
+		if strings.Contains(line, s) {
+			f.statsMu.Lock()
+			f.stats.LastDisconnect = time.Now()
+			f.statsMu.Unlock()
+			return
+		}
+	}
+}
+
+// ProxyStatus queries the frpc admin api for the status of all proxies.
+// Embedded frpc has no admin api and is asked directly.
+func (f *Frpc) ProxyStatus() (client.StatusResp, error) {
+	if f.Embedded() {
+		f.procMu.Lock()
+		p, ok := f.proc.(*serviceProcess)
+		f.procMu.Unlock()
+		if !ok {
+			return nil, errors.New("frpc not running")
+		}
+		return p.status()
+	}
+
 	clientCfg, err := config.UnmarshalClientConfFromIni(path.Join(f.WorkDir, "frpc.ini"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse frpc config: %s", err)
@@ -284,24 +478,5 @@ func (f *Frpc) Status() ([]byte, error) {
 		return nil, fmt.Errorf("parse http response error: %s", err)
 	}
 
-	buf := new(bytes.Buffer)
-	tbl := table.New("Name", "Type", "Status", "Local", "Remote", "Error").WithWriter(buf)
-
-	for k, v := range res {
-		for _, ps := range v {
-			if k == "http" || k == "https" {
-				if ps.LocalAddr != "" {
-					ps.LocalAddr = fmt.Sprintf("%s://%s", k, ps.LocalAddr)
-				}
-				if ps.RemoteAddr != "" {
-					ps.RemoteAddr = fmt.Sprintf("%s://%s", k, ps.RemoteAddr)
-				}
-			}
-			tbl.AddRow(ps.Name, ps.Type, ps.Status, ps.LocalAddr, ps.RemoteAddr, ps.Err)
-		}
-	}
-
-	tbl.Print()
-
-	return buf.Bytes(), nil
+	return res, nil
 }
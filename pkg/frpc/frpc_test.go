@@ -0,0 +1,45 @@
+package frpc
+
+import "testing"
+
+func TestScanConnection(t *testing.T) {
+	// lines as frp v0.51 logs them
+	const (
+		login     = "2023/08/01 10:00:00 [I] [service.go:301] [a1b2c3] login to server success, get run id [a1b2c3]"
+		closed    = "2023/08/01 10:01:00 [I] [service.go:194] [a1b2c3] wait 1s to reconnect"
+		retry     = "2023/08/01 10:01:01 [I] [service.go:214] [a1b2c3] try to reconnect to server..."
+		retryErr  = "2023/08/01 10:01:01 [W] [service.go:217] [a1b2c3] reconnect to server error: dial tcp: connection refused, wait 2s for another retry"
+		loginErr  = "2023/08/01 10:00:00 [W] [service.go:133] login to server failed: dial tcp: connection refused"
+		heartbeat = "2023/08/01 10:01:00 [W] [control.go:305] [a1b2c3] heartbeat timeout"
+		proxy     = "2023/08/01 10:00:00 [I] [proxy_manager.go:142] [a1b2c3] proxy added: [web]"
+	)
+
+	tests := []struct {
+		name      string
+		lines     []string
+		connected bool
+	}{
+		{name: "login", lines: []string{login}, connected: true},
+		{name: "other lines", lines: []string{login, proxy}, connected: true},
+		{name: "closed", lines: []string{login, closed}},
+		{name: "reconnecting", lines: []string{login, closed, retry, retryErr}},
+		{name: "reconnected", lines: []string{login, closed, retry, login}, connected: true},
+		{name: "login failed", lines: []string{loginErr}},
+		{name: "heartbeat timeout", lines: []string{login, heartbeat}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Frpc{}
+			for _, line := range tt.lines {
+				f.scanConnection(line)
+			}
+			stats := f.stats
+			connected := !stats.LastLogin.IsZero() && stats.LastLogin.After(stats.LastDisconnect)
+			if connected != tt.connected {
+				t.Fatalf("connected = %t, want %t (last login %s, last disconnect %s)",
+					connected, tt.connected, stats.LastLogin, stats.LastDisconnect)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package frpc
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/cbodonnell/tfarm/pkg/logging"
+)
+
+// errCannotKill is returned by kill when frpc can only be interrupted.
+var errCannotKill = errors.New("frpc can't be killed")
+
+// process is a running frpc, either a child process or a client.Service
+// in tfarmd's own process.
+type process interface {
+	// wait blocks until frpc exits and returns its exit code, which is -1
+	// if it was killed by a signal.
+	wait() (int, error)
+	// interrupt asks frpc to exit.
+	interrupt() error
+	// kill makes frpc exit now. Embedded frpc can't be killed, only
+	// interrupted, so kill returns errCannotKill and Stop abandons the
+	// run instead.
+	kill() error
+	// pid is 0 when frpc runs in tfarmd's process.
+	pid() int
+}
+
+type execProcess struct {
+	cmd *exec.Cmd
+	// outputDone is done once frpc's output has been read to the end
+	outputDone sync.WaitGroup
+}
+
+// startExec runs the frpc binary against frpc.ini in the work dir. fn is
+// called with each line frpc writes to stdout or stderr.
+func (f *Frpc) startExec(fn func(line string)) (process, error) {
+	cmd := exec.Command(f.binPath, "-c", "frpc.ini")
+	cmd.Dir = f.WorkDir
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &execProcess{cmd: cmd}
+	p.outputDone.Add(2)
+	go func() {
+		defer p.outputDone.Done()
+		logging.LogFrpcOutput(stdout, "stdout", fn)
+	}()
+	go func() {
+		defer p.outputDone.Done()
+		logging.LogFrpcOutput(stderr, "stderr", fn)
+	}()
+
+	return p, nil
+}
+
+func (p *execProcess) wait() (int, error) {
+	// Wait closes the pipes, so the output has to be read first
+	p.outputDone.Wait()
+	err := p.cmd.Wait()
+	return p.cmd.ProcessState.ExitCode(), err
+}
+
+func (p *execProcess) interrupt() error {
+	return p.cmd.Process.Signal(os.Interrupt)
+}
+
+func (p *execProcess) kill() error {
+	return p.cmd.Process.Kill()
+}
+
+func (p *execProcess) pid() int {
+	return p.cmd.Process.Pid
+}
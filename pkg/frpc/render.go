@@ -0,0 +1,156 @@
+package frpc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+)
+
+// localTemplate renders where the tunnel's connections are handled:
+// either a client plugin or a local address.
+const localTemplate = `{{ define "local" }}
+{{- if .Plugin }}
+plugin = {{ .Plugin.Name }}
+{{- range $name, $value := .Plugin.Params }}
+plugin_{{ $name }} = {{ $value }}
+{{- end }}
+{{- else }}
+local_ip = {{ .LocalIP }}
+local_port = {{ .LocalPort }}
+{{- end }}
+{{- end }}`
+
+// optionsTemplate renders the traffic options shared by all tunnel types.
+const optionsTemplate = `{{ define "options" }}
+{{- if .BandwidthLimit }}
+bandwidth_limit = {{ .BandwidthLimit }}
+{{- end }}
+{{- if .UseEncryption }}
+use_encryption = true
+{{- end }}
+{{- if .UseCompression }}
+use_compression = true
+{{- end }}
+{{- if .HealthCheckType }}
+health_check_type = {{ .HealthCheckType }}
+{{- if .HealthCheckURL }}
+health_check_url = {{ .HealthCheckURL }}
+{{- end }}
+{{- if .HealthCheckInterval }}
+health_check_interval_s = {{ .HealthCheckInterval }}
+{{- end }}
+{{- end }}
+{{- if .Group }}
+group = {{ .Group }}
+{{- if .GroupKey }}
+group_key = {{ .GroupKey }}
+{{- end }}
+{{- end }}
+{{- end }}`
+
+const httpTunnelTemplate = `[{{ .Name }}]
+type = {{ .Type }}
+{{- template "local" . }}
+subdomain = TBD
+{{- if .CustomDomains }}
+custom_domains = {{ join .CustomDomains ", " }}
+{{- end }}
+{{- if .Locations }}
+locations = {{ join .Locations ", " }}
+{{- end }}
+{{- if .HostHeaderRewrite }}
+host_header_rewrite = {{ .HostHeaderRewrite }}
+{{- end }}
+{{- if .HTTPUser }}
+http_user = {{ .HTTPUser }}
+http_pwd = {{ .HTTPPwd }}
+{{- end }}
+{{- range $name, $value := .Headers }}
+header_{{ $name }} = {{ $value }}
+{{- end }}
+{{- template "options" . }}
+meta_proxy_id = {{ .ProxyID }}
+`
+
+const tcpUdpTunnelTemplate = `[{{ .Name }}]
+type = {{ .Type }}
+{{- template "local" . }}
+remote_port = {{ .RemotePort }}
+{{- template "options" . }}
+meta_proxy_id = {{ .ProxyID }}
+`
+
+const secretTunnelTemplate = `[{{ .Name }}]
+type = {{ .Type }}
+{{- template "local" . }}
+sk = {{ .SecretKey }}
+{{- if .AllowUsers }}
+allow_users = {{ join .AllowUsers ", " }}
+{{- end }}
+{{- template "options" . }}
+meta_proxy_id = {{ .ProxyID }}
+`
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// tunnelTemplate returns the config template for the given tunnel type.
+func tunnelTemplate(tunnelType string) (*template.Template, error) {
+	var text string
+	switch tunnelType {
+	case "http", "https":
+		text = httpTunnelTemplate
+	case "tcp", "udp":
+		text = tcpUdpTunnelTemplate
+	case "stcp", "xtcp", "sudp":
+		text = secretTunnelTemplate
+	default:
+		return nil, fmt.Errorf("invalid tunnel type: %s", tunnelType)
+	}
+	t := template.New("tunnel").Funcs(templateFuncs)
+	for _, text := range []string{localTemplate, optionsTemplate, text} {
+		t = template.Must(t.Parse(text))
+	}
+	return t, nil
+}
+
+// RenderTunnelConfig renders the config file of a validated create request.
+func RenderTunnelConfig(req *api.CreateRequest) ([]byte, error) {
+	tunnelConfigTemplate, err := tunnelTemplate(req.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnelConfig := &bytes.Buffer{}
+	if err := tunnelConfigTemplate.Execute(tunnelConfig, req); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %s", err)
+	}
+
+	return tunnelConfig.Bytes(), nil
+}
+
+const visitorTemplate = `[{{ .Name }}]
+type = {{ .Type }}
+role = visitor
+server_name = {{ .ServerName }}
+{{- if .ServerUser }}
+server_user = {{ .ServerUser }}
+{{- end }}
+sk = {{ .SecretKey }}
+bind_addr = {{ .BindAddr }}
+bind_port = {{ .BindPort }}
+`
+
+// RenderVisitorConfig renders the config file of a validated visitor request.
+func RenderVisitorConfig(req *api.VisitorRequest) ([]byte, error) {
+	visitorConfig := &bytes.Buffer{}
+	if err := template.Must(template.New("visitor").Parse(visitorTemplate)).Execute(visitorConfig, req); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %s", err)
+	}
+
+	return visitorConfig.Bytes(), nil
+}
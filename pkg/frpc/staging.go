@@ -0,0 +1,147 @@
+package frpc
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/ini.v1"
+)
+
+// Staging is a copy of conf.d that tunnel configs are changed in before
+// the whole directory is verified and swapped in. This lets a set of
+// changes be applied, or rolled back, as one.
+type Staging struct {
+	f   *Frpc
+	dir string
+}
+
+// NewStaging snapshots conf.d into a new staging directory in the work
+// dir. The caller must Close the staging when done with it.
+func (f *Frpc) NewStaging() (*Staging, error) {
+	dir, err := os.MkdirTemp(f.WorkDir, ".staging-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging dir: %s", err)
+	}
+	s := &Staging{f: f, dir: dir}
+
+	if err := copyDir(filepath.Join(f.WorkDir, "conf.d"), s.confDir()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to copy conf.d to staging dir: %s", err)
+	}
+
+	return s, nil
+}
+
+func (s *Staging) confDir() string {
+	return filepath.Join(s.dir, "conf.d")
+}
+
+// TunnelConfigPath returns the path of the staged configuration file for the named tunnel.
+func (s *Staging) TunnelConfigPath(name string) string {
+	return filepath.Join(s.confDir(), name+".ini")
+}
+
+// Verify runs frpc verify against the staged tunnel configs.
+func (s *Staging) Verify() ([]byte, error) {
+	cfg, err := ini.LoadSources(ini.LoadOptions{
+		Insensitive:         false,
+		InsensitiveSections: false,
+		InsensitiveKeys:     false,
+		IgnoreInlineComment: true,
+		AllowBooleanKeys:    true,
+	}, filepath.Join(s.f.WorkDir, "frpc.ini"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frpc.ini: %s", err)
+	}
+
+	// includes are resolved relative to the work dir that frpc runs in
+	rel, err := filepath.Rel(s.f.WorkDir, s.confDir())
+	if err != nil {
+		return nil, err
+	}
+	cfg.Section("common").Key("includes").SetValue("./" + filepath.ToSlash(rel) + "/*.ini")
+
+	configPath := filepath.Join(s.dir, "frpc.ini")
+	if err := cfg.SaveTo(configPath); err != nil {
+		return nil, fmt.Errorf("failed to write staging frpc.ini: %s", err)
+	}
+	if err := os.Chmod(configPath, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write staging frpc.ini: %s", err)
+	}
+
+	configFile, err := filepath.Rel(s.f.WorkDir, configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.f.output("verify", configFile)
+}
+
+// Commit swaps the staged conf.d in and reloads frpc. If the reload
+// fails, the previous conf.d is swapped back and reloaded.
+func (s *Staging) Commit() error {
+	confDir := filepath.Join(s.f.WorkDir, "conf.d")
+	previousDir := filepath.Join(s.dir, "conf.d.previous")
+
+	if err := os.Rename(confDir, previousDir); err != nil {
+		return fmt.Errorf("failed to move conf.d aside: %s", err)
+	}
+	if err := os.Rename(s.confDir(), confDir); err != nil {
+		if err := os.Rename(previousDir, confDir); err != nil {
+			slog.Error("failed to restore conf.d", "err", err)
+		}
+		return fmt.Errorf("failed to swap in staged conf.d: %s", err)
+	}
+
+	if _, err := s.f.Output("reload"); err != nil {
+		if err := s.restore(previousDir); err != nil {
+			slog.Error("failed to restore conf.d", "err", err)
+		} else if _, err := s.f.Output("reload"); err != nil {
+			slog.Error("failed to reload restored conf.d", "err", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *Staging) restore(previousDir string) error {
+	confDir := filepath.Join(s.f.WorkDir, "conf.d")
+	if err := os.Rename(confDir, s.confDir()); err != nil {
+		return err
+	}
+	return os.Rename(previousDir, confDir)
+}
+
+// Close removes the staging dir along with the previous conf.d after a commit.
+func (s *Staging) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+func copyDir(src, dst string) error {
+	if err := os.Mkdir(dst, 0755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), b, 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package frpc
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/auth"
+)
+
+const (
+	exitHistorySize = 20
+	exitOutputLines = 20
+	// stableUptime is how long frpc has to stay up for its failures to be
+	// forgotten.
+	stableUptime = time.Minute
+	// crashLoopFailures is how many failures in a row mark frpc degraded.
+	crashLoopFailures = 5
+)
+
+// Backoff is how long to wait before starting frpc again after it exits.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter is the fraction of the delay it is randomized by, so many
+	// clients don't reconnect to frps in step.
+	Jitter float64
+}
+
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        2 * time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the wait after the given number of failures in a row. It
+// is never more than Max, however many failures there have been.
+func (b Backoff) Delay(failures int) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	maxDelay := float64(b.Max)
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(failures-1))
+	// a long crash loop overflows to +Inf, or NaN for a zero Initial, so
+	// cap the delay before jitter is added
+	if math.IsNaN(d) || d > maxDelay {
+		d = maxDelay
+	}
+	d += d * b.Jitter * (2*rand.Float64() - 1)
+	if d > maxDelay {
+		d = maxDelay
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// supervisor keeps track of frpc's runs for StartLoop and /api/frpc. It is
+// only ever locked after procMu, never before.
+type supervisor struct {
+	// now is the clock, time.Now unless a test sets it
+	now func() time.Time
+
+	mu        sync.Mutex
+	state     string
+	startedAt time.Time
+	failures  int
+	nextStart time.Time
+	exits     []api.FrpcExit
+	// output is the tail of what the current run of frpc wrote. frpc logs
+	// to stdout, so both streams are kept.
+	output []string
+}
+
+func (s *supervisor) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+func (s *supervisor) setState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+}
+
+func (s *supervisor) started() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = api.FrpcStateRunning
+	s.startedAt = s.clock()
+	s.nextStart = time.Time{}
+	s.output = nil
+}
+
+func (s *supervisor) recordOutput(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output = append(s.output, line)
+	if len(s.output) > exitOutputLines {
+		s.output = s.output[len(s.output)-exitOutputLines:]
+	}
+}
+
+// failed records an unexpected exit or a failed start and returns how many
+// failures there have been in a row.
+func (s *supervisor) failed(exitCode int, err error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exit := api.FrpcExit{
+		Time:     s.clock(),
+		ExitCode: exitCode,
+		Error:    err.Error(),
+	}
+	if !s.startedAt.IsZero() {
+		uptime := exit.Time.Sub(s.startedAt)
+		exit.UptimeSeconds = uptime.Seconds()
+		exit.Output = s.output
+		if uptime >= stableUptime {
+			s.failures = 0
+		}
+	}
+	s.startedAt = time.Time{}
+	s.output = nil
+
+	s.exits = append(s.exits, exit)
+	if len(s.exits) > exitHistorySize {
+		s.exits = s.exits[len(s.exits)-exitHistorySize:]
+	}
+	s.failures++
+
+	return s.failures
+}
+
+func (s *supervisor) backoff(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = api.FrpcStateBackoff
+	s.nextStart = s.clock().Add(delay)
+}
+
+// status fills in the supervisor's part of st.
+func (s *supervisor) status(st *api.FrpcStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.State = s.state
+	st.ConsecutiveFailures = s.failures
+	st.Exits = make([]api.FrpcExit, len(s.exits))
+	copy(st.Exits, s.exits)
+
+	if !s.startedAt.IsZero() {
+		startedAt := s.startedAt
+		st.StartedAt = &startedAt
+		if s.clock().Sub(startedAt) >= stableUptime {
+			st.ConsecutiveFailures = 0
+		}
+	}
+	st.Degraded = st.ConsecutiveFailures >= crashLoopFailures
+	if !s.nextStart.IsZero() {
+		nextStart := s.nextStart
+		st.NextStart = &nextStart
+	}
+}
+
+func (s *supervisor) consecutiveFailures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures
+}
+
+// StartLoop supervises frpc until Shutdown: it waits for credentials,
+// starts frpc and starts it again after f.Backoff whenever it exits or
+// fails to start.
+func (f *Frpc) StartLoop() {
+	go func() {
+		for {
+			f.sup.setState(api.FrpcStateWaiting)
+			if err := f.signConfig(); err != nil {
+				slog.Error("failed to start frpc", "err", err)
+				f.failed(-1, err)
+			} else {
+				f.StartAndWait()
+				select {
+				case err := <-f.ErrChan:
+					slog.Error("frpc exited", "err", err)
+				case <-f.shutdownChan:
+					f.sup.setState(api.FrpcStateStopped)
+					return
+				}
+			}
+
+			delay := f.Backoff.Delay(f.sup.consecutiveFailures())
+			f.sup.backoff(delay)
+			slog.Info("restarting frpc", "delay", delay)
+			select {
+			case <-time.After(delay):
+			case <-f.shutdownChan:
+				f.sup.setState(api.FrpcStateStopped)
+				return
+			}
+		}
+	}()
+}
+
+func (f *Frpc) signConfig() error {
+	creds, err := auth.WaitForCredentials(f.WorkDir)
+	if err != nil {
+		return fmt.Errorf("error waiting for credentials: %s", err)
+	}
+
+	f.LockConfig()
+	defer f.UnlockConfig()
+	if err := f.SignConfig(creds); err != nil {
+		return fmt.Errorf("error signing frpc config: %s", err)
+	}
+
+	return nil
+}
+
+// failed records an unexpected exit or a failed start, and reports frpc
+// degraded when it has failed crashLoopFailures times in a row.
+func (f *Frpc) failed(exitCode int, err error) {
+	if n := f.sup.failed(exitCode, err); n == crashLoopFailures {
+		slog.Error("frpc is crash looping", "failures", n)
+		f.Events.Publish(api.Event{Type: api.EventFrpcDegraded, Message: fmt.Sprintf("frpc failed %d times in a row", n)})
+	}
+}
+
+// Supervisor returns the state of the frpc process and its supervisor.
+func (f *Frpc) Supervisor() *api.FrpcStatus {
+	st := &api.FrpcStatus{Starts: f.Stats().Starts}
+
+	f.procMu.Lock()
+	running := f.proc != nil
+	if running {
+		st.PID = f.proc.pid()
+	}
+	f.procMu.Unlock()
+
+	f.sup.status(st)
+	if !running {
+		// stopped for a restart or shutdown rather than exited
+		st.StartedAt = nil
+	}
+
+	return st
+}
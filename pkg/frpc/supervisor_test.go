@@ -0,0 +1,135 @@
+package frpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/events"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	noJitter := DefaultBackoff
+	noJitter.Jitter = 0
+
+	tests := []struct {
+		name     string
+		backoff  Backoff
+		failures int
+		want     time.Duration
+	}{
+		{name: "no failures", backoff: noJitter, failures: 0, want: time.Second},
+		{name: "first failure", backoff: noJitter, failures: 1, want: time.Second},
+		{name: "second failure", backoff: noJitter, failures: 2, want: 2 * time.Second},
+		{name: "fifth failure", backoff: noJitter, failures: 5, want: 16 * time.Second},
+		{name: "capped", backoff: noJitter, failures: 9, want: 2 * time.Minute},
+		{name: "overflow", backoff: noJitter, failures: 2000, want: 2 * time.Minute},
+		{name: "overflow with jitter", backoff: Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 2, Jitter: 1}, failures: 1 << 30},
+		{name: "zero initial overflow", backoff: Backoff{Max: time.Minute, Multiplier: 2}, failures: 2000, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.backoff.Delay(tt.failures)
+			if tt.backoff.Jitter == 0 {
+				if got != tt.want {
+					t.Fatalf("Delay(%d) = %s, want %s", tt.failures, got, tt.want)
+				}
+				return
+			}
+			if got < 0 || got > tt.backoff.Max {
+				t.Fatalf("Delay(%d) = %s, want between 0 and %s", tt.failures, got, tt.backoff.Max)
+			}
+		})
+	}
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+	b := DefaultBackoff
+	for i := 0; i < 1000; i++ {
+		d := b.Delay(3)
+		if d < 3200*time.Millisecond || d > 4800*time.Millisecond {
+			t.Fatalf("Delay(3) = %s, want 4s +/- 20%%", d)
+		}
+		if d := b.Delay(100); d > b.Max || d < b.Max*8/10 {
+			t.Fatalf("Delay(100) = %s, want at most %s and within jitter of it", d, b.Max)
+		}
+	}
+}
+
+// fakeClock is a clock tests move by hand.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestCrashLoopDetection(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2023, 8, 1, 10, 0, 0, 0, time.UTC)}
+	f := &Frpc{Events: events.NewBus()}
+	f.sup.now = clock.now
+	errExit := errors.New("exit status 1")
+
+	status := func() *api.FrpcStatus {
+		st := &api.FrpcStatus{}
+		f.sup.status(st)
+		return st
+	}
+	crash := func(uptime time.Duration) {
+		f.sup.started()
+		clock.advance(uptime)
+		f.failed(1, errExit)
+	}
+
+	published, unsubscribe := f.Events.Subscribe()
+	defer unsubscribe()
+
+	for i := 1; i < crashLoopFailures; i++ {
+		crash(time.Second)
+		if st := status(); st.Degraded || st.ConsecutiveFailures != i {
+			t.Fatalf("after %d failures: degraded = %t, failures = %d", i, st.Degraded, st.ConsecutiveFailures)
+		}
+	}
+	select {
+	case e := <-published:
+		t.Fatalf("unexpected event %+v", e)
+	default:
+	}
+
+	crash(time.Second)
+	if st := status(); !st.Degraded || st.ConsecutiveFailures != crashLoopFailures {
+		t.Fatalf("degraded = %t, failures = %d, want degraded", st.Degraded, st.ConsecutiveFailures)
+	}
+	select {
+	case e := <-published:
+		if e.Type != api.EventFrpcDegraded {
+			t.Fatalf("event = %+v, want %s", e, api.EventFrpcDegraded)
+		}
+	default:
+		t.Fatal("no degraded event was published")
+	}
+
+	// a run that stays up is no longer counted as crash looping
+	f.sup.started()
+	clock.advance(stableUptime)
+	if st := status(); st.Degraded || st.ConsecutiveFailures != 0 {
+		t.Fatalf("after a stable run: degraded = %t, failures = %d", st.Degraded, st.ConsecutiveFailures)
+	}
+
+	// and its exit starts the count again
+	f.failed(1, errExit)
+	if st := status(); st.Degraded || st.ConsecutiveFailures != 1 {
+		t.Fatalf("after a stable run exited: degraded = %t, failures = %d", st.Degraded, st.ConsecutiveFailures)
+	}
+	if n := len(status().Exits); n != crashLoopFailures+1 {
+		t.Fatalf("exits = %d, want %d", n, crashLoopFailures+1)
+	}
+
+	f.sup.backoff(time.Minute)
+	if st := status(); st.State != api.FrpcStateBackoff || st.NextStart == nil || !st.NextStart.Equal(clock.t.Add(time.Minute)) {
+		t.Fatalf("state = %s, next start = %v", st.State, st.NextStart)
+	}
+}
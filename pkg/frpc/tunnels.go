@@ -0,0 +1,169 @@
+package frpc
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/fatedier/frp/pkg/config"
+	"gopkg.in/ini.v1"
+)
+
+// TunnelConf is a parsed tunnel configuration file. Exactly one of Proxy
+// or Visitor is set: secret tunnel types (stcp, xtcp, sudp) can also be
+// configured as a visitor that connects to another client's proxy.
+type TunnelConf struct {
+	Proxy   config.ProxyConf
+	Visitor config.VisitorConf
+}
+
+// IsVisitor reports whether the tunnel is a visitor.
+func (c *TunnelConf) IsVisitor() bool {
+	return c.Visitor != nil
+}
+
+// TunnelConfigPath returns the path of the configuration file for the named tunnel.
+func (f *Frpc) TunnelConfigPath(name string) string {
+	return filepath.Join(f.WorkDir, "conf.d", name+".ini")
+}
+
+// ParseTunnelConfig parses the named section of a tunnel configuration file.
+// The source can be a string, []byte, or io.Reader.
+func ParseTunnelConfig(name string, source interface{}) (*TunnelConf, error) {
+	f, err := ini.LoadSources(ini.LoadOptions{
+		Insensitive:         false,
+		InsensitiveSections: false,
+		InsensitiveKeys:     false,
+		IgnoreInlineComment: true,
+		AllowBooleanKeys:    true,
+	}, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tunnel config %s: %s", name, err)
+	}
+
+	s, err := f.GetSection(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tunnel config %s, not found [%s] section", name, name)
+	}
+
+	if s.Key("role").String() == "visitor" {
+		visitor, err := config.NewVisitorConfFromIni("", name, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid visitor config %s: %s", name, err)
+		}
+		return &TunnelConf{Visitor: visitor}, nil
+	}
+
+	proxy, err := config.NewProxyConfFromIni("", name, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tunnel config %s: %s", name, err)
+	}
+
+	return &TunnelConf{Proxy: proxy}, nil
+}
+
+// CreateRequest reconstructs the create request that renders to the
+// tunnel's config. It returns nil for visitors.
+func (c *TunnelConf) CreateRequest(name string) *api.CreateRequest {
+	if c.IsVisitor() {
+		return nil
+	}
+
+	base := c.Proxy.GetBaseConfig()
+	req := &api.CreateRequest{
+		Name:      name,
+		Type:      base.ProxyType,
+		LocalIP:   base.LocalIP,
+		LocalPort: base.LocalPort,
+		ProxyID:   base.Metas["proxy_id"],
+
+		BandwidthLimit:      base.BandwidthLimit.String(),
+		UseEncryption:       base.UseEncryption,
+		UseCompression:      base.UseCompression,
+		HealthCheckType:     base.HealthCheckType,
+		HealthCheckURL:      healthCheckPath(base.HealthCheckURL),
+		HealthCheckInterval: base.HealthCheckIntervalS,
+		Group:               base.Group,
+		GroupKey:            base.GroupKey,
+	}
+
+	if base.Plugin != "" {
+		req.Plugin = &api.Plugin{Name: base.Plugin}
+		for k, v := range base.PluginParams {
+			if req.Plugin.Params == nil {
+				req.Plugin.Params = make(map[string]string)
+			}
+			req.Plugin.Params[strings.TrimPrefix(k, "plugin_")] = v
+		}
+		// frp fills in a default local address that plugin tunnels don't use
+		req.LocalIP = ""
+		req.LocalPort = 0
+	}
+
+	switch p := c.Proxy.(type) {
+	case *config.TCPProxyConf:
+		req.RemotePort = p.RemotePort
+	case *config.UDPProxyConf:
+		req.RemotePort = p.RemotePort
+	case *config.HTTPProxyConf:
+		req.CustomDomains = p.CustomDomains
+		req.Locations = p.Locations
+		req.HostHeaderRewrite = p.HostHeaderRewrite
+		req.HTTPUser = p.HTTPUser
+		req.HTTPPwd = p.HTTPPwd
+		if len(p.Headers) > 0 {
+			req.Headers = p.Headers
+		}
+	case *config.HTTPSProxyConf:
+		req.CustomDomains = p.CustomDomains
+	case *config.STCPProxyConf:
+		req.SecretKey = p.Sk
+		req.AllowUsers = p.AllowUsers
+	case *config.XTCPProxyConf:
+		req.SecretKey = p.Sk
+		req.AllowUsers = p.AllowUsers
+	case *config.SUDPProxyConf:
+		req.SecretKey = p.Sk
+		req.AllowUsers = p.AllowUsers
+	}
+
+	return req
+}
+
+// healthCheckPath strips the local address that frp prepends to
+// health check urls when parsing.
+func healthCheckPath(healthCheckURL string) string {
+	u, err := url.Parse(healthCheckURL)
+	if err != nil || u.Host == "" {
+		return healthCheckURL
+	}
+	return u.RequestURI()
+}
+
+// VisitorRequest reconstructs the visitor request that renders to the
+// tunnel's config. It returns nil for proxies.
+func (c *TunnelConf) VisitorRequest(name string) *api.VisitorRequest {
+	if !c.IsVisitor() {
+		return nil
+	}
+
+	base := c.Visitor.GetBaseConfig()
+
+	// frp prefixes the server name with the server user when parsing
+	serverName := base.ServerName
+	if base.ServerUser != "" {
+		serverName = strings.TrimPrefix(serverName, base.ServerUser+".")
+	}
+
+	return &api.VisitorRequest{
+		Name:       name,
+		Type:       base.ProxyType,
+		ServerName: serverName,
+		ServerUser: base.ServerUser,
+		SecretKey:  base.Sk,
+		BindAddr:   base.BindAddr,
+		BindPort:   base.BindPort,
+	}
+}
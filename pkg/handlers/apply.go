@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/google/uuid"
+)
+
+func HandleApply(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var applyRequest api.ApplyRequest
+		if err := json.NewDecoder(r.Body).Decode(&applyRequest); err != nil {
+			slog.ErrorContext(r.Context(), "failed to decode request body", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, "failed to decode request body")
+			return
+		}
+
+		desiredNames := make(map[string]bool)
+		for i := range applyRequest.Tunnels {
+			desired := &applyRequest.Tunnels[i]
+			if err := validateCreateRequest(desired); err != nil {
+				slog.WarnContext(r.Context(), "invalid apply request", "err", err)
+				api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid tunnel %s: %s", desired.Name, err))
+				return
+			}
+			if desiredNames[desired.Name] {
+				slog.WarnContext(r.Context(), "duplicate tunnel in apply request", "tunnel", desired.Name)
+				api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("duplicate tunnel: %s", desired.Name))
+				return
+			}
+			desiredNames[desired.Name] = true
+		}
+
+		tunnels, err := b.Tunnels()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to read tunnel configs", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to read tunnel configs")
+			return
+		}
+
+		result := &api.ApplyResponse{
+			Created:   []string{},
+			Updated:   []string{},
+			Deleted:   []string{},
+			Unchanged: []string{},
+			DryRun:    applyRequest.DryRun,
+		}
+		changes := make(backend.TunnelChanges)
+
+		for i := range applyRequest.Tunnels {
+			desired := &applyRequest.Tunnels[i]
+			original := tunnels[desired.Name]
+
+			// keep the proxy id of existing tunnels so unchanged tunnels compare equal
+			desired.ProxyID = ""
+			if original != nil && !original.IsVisitor() {
+				desired.ProxyID = original.Proxy.ProxyID
+			}
+			if desired.ProxyID == "" {
+				desired.ProxyID = uuid.New().String()
+			}
+			tunnel := &backend.Tunnel{Proxy: desired}
+
+			switch {
+			case original == nil:
+				result.Created = append(result.Created, desired.Name)
+			case original.Equal(tunnel):
+				if metadataMatches(s, desired) {
+					result.Unchanged = append(result.Unchanged, desired.Name)
+				} else {
+					result.Updated = append(result.Updated, desired.Name)
+				}
+				continue
+			default:
+				result.Updated = append(result.Updated, desired.Name)
+			}
+
+			changes[desired.Name] = tunnel
+		}
+
+		// visitors can't be described by a manifest, so they are never pruned
+		if applyRequest.Prune {
+			for name, t := range tunnels {
+				if desiredNames[name] || t.IsVisitor() {
+					continue
+				}
+				changes[name] = nil
+				result.Deleted = append(result.Deleted, name)
+			}
+			sort.Strings(result.Deleted)
+		}
+
+		message := fmt.Sprintf("%d created, %d updated, %d deleted, %d unchanged",
+			len(result.Created), len(result.Updated), len(result.Deleted), len(result.Unchanged))
+
+		if applyRequest.DryRun {
+			api.RespondWithData(w, "dry run: "+message, result)
+			return
+		}
+
+		if len(changes) > 0 {
+			if message, err := applyTunnels(b, changes); err != nil {
+				slog.ErrorContext(r.Context(), message, "err", err)
+				api.RespondWithError(w, http.StatusInternalServerError, message)
+				return
+			}
+		}
+
+		creator := requestCreator(r)
+		metadata := newMetadataChanges(s)
+		for i := range applyRequest.Tunnels {
+			desired := &applyRequest.Tunnels[i]
+			if contains(result.Created, desired.Name) {
+				metadata.create(desired.Name, newMetadata(desired, creator))
+			} else if contains(result.Updated, desired.Name) {
+				metadata.update(desired.Name, func(m *state.Metadata, now time.Time) {
+					updateTunnelMetadata(m, desired, now)
+				})
+			}
+		}
+		for _, name := range result.Deleted {
+			metadata.delete(name)
+		}
+		if err := metadata.save(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to save metadata", "err", err)
+			if len(changes) > 0 {
+				revertTunnels(b, previousTunnels(tunnels, changes))
+			}
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to save tunnel metadata, no changes were applied")
+			return
+		}
+
+		publishTunnelEvents(b, api.EventTunnelCreated, result.Created...)
+		publishTunnelEvents(b, api.EventTunnelUpdated, result.Updated...)
+		publishTunnelEvents(b, api.EventTunnelDeleted, result.Deleted...)
+
+		api.RespondWithData(w, message, result)
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/google/uuid"
+)
+
+func HandleBatch(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var batchRequest api.BatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&batchRequest); err != nil {
+			slog.ErrorContext(r.Context(), "failed to decode request body", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, "failed to decode request body")
+			return
+		}
+
+		if len(batchRequest.Operations) == 0 {
+			api.RespondWithError(w, http.StatusBadRequest, "no operations")
+			return
+		}
+
+		// kept to undo the batch if its metadata can't be saved
+		tunnels, err := b.Tunnels()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to read tunnel configs", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to read tunnel configs")
+			return
+		}
+
+		res := &api.BatchResponse{Results: make([]api.BatchResult, len(batchRequest.Operations))}
+		changes := make(backend.TunnelChanges)
+		seen := make(map[string]bool)
+		failed := 0
+		for i := range batchRequest.Operations {
+			op := &batchRequest.Operations[i]
+			if op.Name == "" && op.Tunnel != nil {
+				op.Name = op.Tunnel.Name
+			}
+			res.Results[i] = api.BatchResult{Action: op.Action, Name: op.Name}
+
+			err := stageOperation(b, changes, op)
+			if err == nil && seen[op.Name] {
+				err = fmt.Errorf("multiple operations for tunnel %s", op.Name)
+			}
+			seen[op.Name] = true
+			if err != nil {
+				res.Results[i].Error = err.Error()
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			slog.WarnContext(r.Context(), "rejected batch", "failed", failed, "operations", len(res.Results))
+			api.RespondWithErrorData(w, http.StatusBadRequest, fmt.Sprintf("%d of %d operations failed, no changes were applied", failed, len(res.Results)), res)
+			return
+		}
+
+		if message, err := applyTunnels(b, changes); err != nil {
+			slog.ErrorContext(r.Context(), "failed to apply batch", "err", err)
+			api.RespondWithErrorData(w, http.StatusInternalServerError, message+", no changes were applied", res)
+			return
+		}
+
+		creator := requestCreator(r)
+		metadata := newMetadataChanges(s)
+		for _, op := range batchRequest.Operations {
+			switch op.Action {
+			case "create":
+				metadata.create(op.Name, newMetadata(op.Tunnel, creator))
+			case "update":
+				tunnel := op.Tunnel
+				metadata.update(op.Name, func(m *state.Metadata, now time.Time) {
+					updateTunnelMetadata(m, tunnel, now)
+				})
+			case "delete":
+				metadata.delete(op.Name)
+			}
+		}
+		if err := metadata.save(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to save metadata", "err", err)
+			revertTunnels(b, previousTunnels(tunnels, changes))
+			api.RespondWithErrorData(w, http.StatusInternalServerError, "failed to save tunnel metadata, no changes were applied", res)
+			return
+		}
+
+		for _, op := range batchRequest.Operations {
+			switch op.Action {
+			case "create":
+				publishTunnelEvents(b, api.EventTunnelCreated, op.Name)
+			case "update":
+				publishTunnelEvents(b, api.EventTunnelUpdated, op.Name)
+			case "delete":
+				publishTunnelEvents(b, api.EventTunnelDeleted, op.Name)
+			}
+		}
+
+		api.RespondWithData(w, fmt.Sprintf("%d operations applied", len(res.Results)), res)
+	}
+}
+
+// stageOperation adds a batch operation to the changes. The batch has one
+// operation per tunnel, so each is checked against the current tunnels.
+func stageOperation(b backend.Backend, changes backend.TunnelChanges, op *api.BatchOperation) error {
+	if err := validateTunnelName(op.Name); err != nil {
+		return err
+	}
+
+	switch op.Action {
+	case "create", "update":
+		if op.Tunnel == nil {
+			return fmt.Errorf("tunnel is required to %s", op.Action)
+		}
+		if op.Tunnel.Name != op.Name {
+			return fmt.Errorf("name %s does not match tunnel name %s", op.Name, op.Tunnel.Name)
+		}
+		if err := validateCreateRequest(op.Tunnel); err != nil {
+			return err
+		}
+
+		t, err := b.Tunnel(op.Name)
+		switch {
+		case op.Action == "create" && err == nil:
+			return fmt.Errorf("tunnel already exists: %s", op.Name)
+		case op.Action == "create" && os.IsNotExist(err):
+			op.Tunnel.ProxyID = uuid.New().String()
+		case op.Action == "update" && os.IsNotExist(err):
+			return fmt.Errorf("tunnel does not exist: %s", op.Name)
+		case err != nil:
+			return fmt.Errorf("failed to read tunnel config: %s", err)
+		case t.IsVisitor():
+			return fmt.Errorf("cannot update visitor: %s", op.Name)
+		default:
+			op.Tunnel.ProxyID = t.Proxy.ProxyID
+		}
+
+		changes[op.Name] = &backend.Tunnel{Proxy: op.Tunnel}
+		return nil
+	case "delete":
+		if op.Tunnel != nil {
+			return errors.New("tunnel is not valid for delete")
+		}
+		if _, err := b.Tunnel(op.Name); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("tunnel does not exist: %s", op.Name)
+			}
+			return fmt.Errorf("failed to read tunnel config: %s", err)
+		}
+		changes[op.Name] = nil
+		return nil
+	default:
+		return fmt.Errorf("invalid action: %q, must be create, update or delete", op.Action)
+	}
+}
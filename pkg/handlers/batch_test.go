@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+)
+
+const apiTunnel = `{"name":"api","type":"http","local_ip":"127.0.0.1","local_port":3000}`
+
+// batchResults decodes the per-operation results of a batch response.
+func batchResults(t *testing.T, resp *api.Response) []api.BatchResult {
+	t.Helper()
+	b, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &api.BatchResponse{}
+	if err := json.Unmarshal(b, res); err != nil {
+		t.Fatalf("invalid batch response %s: %s", b, err)
+	}
+	return res.Results
+}
+
+// newBatchTestServer serves the api with the web and api tunnels created.
+func newBatchTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := newTestServer(t)
+	for _, tunnel := range []string{webTunnel, apiTunnel} {
+		if status, resp := ts.do("POST", "/api/tunnel", tunnel); status != http.StatusOK {
+			t.Fatalf("create: status = %d: %s", status, resp.Error)
+		}
+	}
+	return ts
+}
+
+// snapshot returns the fake's tunnels and the state store's metadata, to
+// check that a failed batch changed neither.
+func (ts *testServer) snapshot() (map[string]*backend.Tunnel, map[string]state.Metadata) {
+	ts.t.Helper()
+	tunnels, err := ts.b.Tunnels()
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return tunnels, ts.s.All()
+}
+
+func TestBatch(t *testing.T) {
+	ts := newBatchTestServer(t)
+	web, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"operations":[` +
+		`{"action":"create","tunnel":` + dbTunnel + `},` +
+		`{"action":"update","name":"web","tunnel":{"name":"web","type":"http","local_ip":"127.0.0.1","local_port":9090}},` +
+		`{"action":"delete","name":"api"}]}`
+	status, resp := ts.do("POST", "/api/tunnels/batch", body)
+	if status != http.StatusOK || resp.Message != "3 operations applied" {
+		t.Fatalf("status = %d: %s%s", status, resp.Message, resp.Error)
+	}
+
+	want := []api.BatchResult{
+		{Action: "create", Name: "db"},
+		{Action: "update", Name: "web"},
+		{Action: "delete", Name: "api"},
+	}
+	if got := batchResults(t, resp); !reflect.DeepEqual(got, want) {
+		t.Fatalf("results = %+v, want %+v", got, want)
+	}
+
+	if _, err := ts.b.Tunnel("db"); err != nil {
+		t.Fatalf("db was not created: %s", err)
+	}
+	updated, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Proxy.LocalPort != 9090 || updated.Proxy.ProxyID != web.Proxy.ProxyID {
+		t.Fatalf("web = %+v, want local_port 9090 and proxy id %s", updated.Proxy, web.Proxy.ProxyID)
+	}
+	if _, err := ts.b.Tunnel("api"); !os.IsNotExist(err) {
+		t.Fatalf("api was not deleted: %v", err)
+	}
+	if got, want := stateNames(ts.s), []string{"db", "web"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("state has %v, want %v", got, want)
+	}
+}
+
+// TestBatchRejected makes sure invalid operations fail the whole batch,
+// with an error on each operation that caused it.
+func TestBatchRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+		want    []api.BatchResult
+	}{
+		{
+			name:    "duplicate names",
+			body:    `{"operations":[{"action":"delete","name":"api"},{"action":"create","tunnel":` + dbTunnel + `},{"action":"delete","name":"api"}]}`,
+			message: "1 of 3 operations failed, no changes were applied",
+			want: []api.BatchResult{
+				{Action: "delete", Name: "api"},
+				{Action: "create", Name: "db"},
+				{Action: "delete", Name: "api", Error: "multiple operations for tunnel api"},
+			},
+		},
+		{
+			name:    "unknown action",
+			body:    `{"operations":[{"action":"create","tunnel":` + dbTunnel + `},{"action":"rename","name":"web"}]}`,
+			message: "1 of 2 operations failed, no changes were applied",
+			want: []api.BatchResult{
+				{Action: "create", Name: "db"},
+				{Action: "rename", Name: "web", Error: `invalid action: "rename", must be create, update or delete`},
+			},
+		},
+		{
+			name:    "unknown tunnel",
+			body:    `{"operations":[{"action":"delete","name":"api"},{"action":"delete","name":"db"}]}`,
+			message: "1 of 2 operations failed, no changes were applied",
+			want: []api.BatchResult{
+				{Action: "delete", Name: "api"},
+				{Action: "delete", Name: "db", Error: "tunnel does not exist: db"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newBatchTestServer(t)
+			tunnels, metadata := ts.snapshot()
+
+			status, resp := ts.do("POST", "/api/tunnels/batch", tt.body)
+			if status != http.StatusBadRequest || resp.Error != tt.message {
+				t.Fatalf("status = %d, error = %q, want %d %q", status, resp.Error, http.StatusBadRequest, tt.message)
+			}
+			if got := batchResults(t, resp); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("results = %+v, want %+v", got, tt.want)
+			}
+
+			if after, m := ts.snapshot(); !reflect.DeepEqual(after, tunnels) || !reflect.DeepEqual(m, metadata) {
+				t.Fatal("the rejected batch changed the tunnels")
+			}
+		})
+	}
+}
+
+// TestBatchFailedChangesRollBack makes sure a batch the backend rejects, or
+// fails to reload, leaves the tunnels and their metadata as they were.
+func TestBatchFailedChangesRollBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    func(b *backend.Fake, err error)
+		message string
+	}{
+		{name: "verify", fail: (*backend.Fake).FailVerify, message: "failed to verify, no changes were applied"},
+		{name: "reload", fail: (*backend.Fake).FailReload, message: "failed to reload, no changes were applied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newBatchTestServer(t)
+			tunnels, metadata := ts.snapshot()
+
+			tt.fail(ts.b, errors.New("rejected"))
+			body := `{"operations":[` +
+				`{"action":"create","tunnel":` + dbTunnel + `},` +
+				`{"action":"update","name":"web","tunnel":{"name":"web","type":"http","local_ip":"127.0.0.1","local_port":9090,"ttl":"1h"}},` +
+				`{"action":"delete","name":"api"}]}`
+			status, resp := ts.do("POST", "/api/tunnels/batch", body)
+			if status != http.StatusInternalServerError || resp.Error != tt.message {
+				t.Fatalf("status = %d, error = %q, want %d %q", status, resp.Error, http.StatusInternalServerError, tt.message)
+			}
+			if got := batchResults(t, resp); len(got) != 3 {
+				t.Fatalf("results = %+v, want 3", got)
+			}
+
+			after, m := ts.snapshot()
+			if !reflect.DeepEqual(after, tunnels) {
+				t.Fatal("the tunnels were changed")
+			}
+			if !reflect.DeepEqual(m, metadata) {
+				t.Fatalf("the metadata was changed to %+v", m)
+			}
+			saved, err := state.Open(ts.statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the saved times have no monotonic clock reading, so compare them as json
+			got, _ := json.Marshal(saved.All())
+			want, _ := json.Marshal(metadata)
+			if string(got) != string(want) {
+				t.Fatalf("the saved metadata was changed to %s", got)
+			}
+		})
+	}
+}
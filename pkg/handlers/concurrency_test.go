@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/auth"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/events"
+	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/fatedier/frp/pkg/config"
+)
+
+// fakeFrpc stands in for the frpc binary. It logs reloads to frpc.log in
+// the work dir, and fails verify or reload while fail-verify or
+// fail-reload exists there.
+const fakeFrpc = `#!/bin/sh
+case "$1" in
+verify)
+	[ -e fail-verify ] && exit 1
+	;;
+reload)
+	echo reload >> frpc.log
+	[ -e fail-reload ] && exit 1
+	;;
+*)
+	trap 'exit 0' INT TERM
+	while true; do sleep 0.1; done
+	;;
+esac
+exit 0
+`
+
+// newFrpcTestServer serves the api against the frpc backend, running the
+// fake frpc in a temporary work dir.
+func newFrpcTestServer(t *testing.T) (*testServer, *frpc.Frpc) {
+	t.Helper()
+
+	workDir := t.TempDir()
+	binPath := filepath.Join(workDir, "frpc")
+	if err := os.WriteFile(binPath, []byte(fakeFrpc), 0755); err != nil {
+		t.Fatal(err)
+	}
+	creds := `{"client_id":"test","client_secret":"c2VjcmV0","client_ca_cert":"Y2E=","client_tls_cert":"Y2VydA==","client_tls_key":"a2V5"}`
+	if err := os.WriteFile(filepath.Join(workDir, "credentials.json"), []byte(creds), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.WaitForCredentials(workDir); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := frpc.New(binPath, workDir, config.GetDefaultClientConf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Events = events.NewBus()
+	f.StartAndWait()
+	for deadline := time.Now().Add(5 * time.Second); !f.IsCmd(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("frpc did not start")
+		}
+	}
+	t.Cleanup(func() {
+		if err := f.Shutdown(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	statePath := filepath.Join(workDir, "tunnels.json")
+	s, err := state.Open(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewMuxHandler(backend.NewFrpc(f), s, nil))
+	t.Cleanup(srv.Close)
+
+	return &testServer{t: t, srv: srv, s: s, statePath: statePath}, f
+}
+
+// confDNames returns the names of the tunnels in conf.d, sorted.
+func confDNames(t *testing.T, workDir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(workDir, "conf.d", "*.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(paths))
+	for _, p := range paths {
+		name := strings.TrimSuffix(filepath.Base(p), ".ini")
+		if _, err := frpc.ParseTunnelConfig(name, p); err != nil {
+			t.Errorf("conf.d has an invalid config: %s", err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stateNames returns the names of the tunnels in the state store, sorted.
+func stateNames(s *state.Store) []string {
+	names := make([]string, 0)
+	for name := range s.All() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestConcurrentChanges runs creates, updates, deletes, batches and
+// applies in parallel with readers, and makes sure conf.d and the state
+// store agree once they are done. Run it with -race.
+func TestConcurrentChanges(t *testing.T) {
+	ts, f := newFrpcTestServer(t)
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*10)
+	expect := func(method, path, body string, want int) {
+		status, resp := ts.do(method, path, body)
+		if status != want {
+			errs <- fmt.Errorf("%s %s: status = %d, want %d: %s", method, path, status, want, resp.Error)
+		}
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			tunnel := func(name string, port int) string {
+				return fmt.Sprintf(`{"name":"%s","type":"tcp","local_ip":"127.0.0.1","local_port":%d,"remote_port":%d}`, name, port, 20000+port)
+			}
+			a, b, c, d := fmt.Sprintf("a%d", w), fmt.Sprintf("b%d", w), fmt.Sprintf("c%d", w), fmt.Sprintf("d%d", w)
+
+			expect("POST", "/api/tunnel", tunnel(a, 1000+w), http.StatusOK)
+			expect("PATCH", "/api/tunnel/"+a, fmt.Sprintf(`{"local_port":%d}`, 2000+w), http.StatusOK)
+			expect("POST", "/api/tunnel", tunnel(b, 3000+w), http.StatusOK)
+			expect("DELETE", "/api/tunnel/"+b, "", http.StatusOK)
+			expect("POST", "/api/apply", `{"tunnels":[`+tunnel(a, 4000+w)+`,`+tunnel(c, 5000+w)+`]}`, http.StatusOK)
+			expect("POST", "/api/tunnels/batch", `{"operations":[{"action":"create","tunnel":`+tunnel(d, 6000+w)+`},{"action":"delete","name":"`+c+`"}]}`, http.StatusOK)
+			expect("POST", "/api/visitor", fmt.Sprintf(`{"name":"v%d","type":"stcp","server_name":"x","sk":"secret","bind_port":%d}`, w, 9000+w), http.StatusOK)
+		}(w)
+
+		// readers take the config read lock alongside the writers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				expect("GET", "/api/verify", "", http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var want []string
+	for w := 0; w < workers; w++ {
+		want = append(want, fmt.Sprintf("a%d", w), fmt.Sprintf("d%d", w), fmt.Sprintf("v%d", w))
+	}
+	sort.Strings(want)
+
+	f.RLockConfig()
+	defer f.RUnlockConfig()
+
+	if got := confDNames(t, f.WorkDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.d has %v, want %v", got, want)
+	}
+	if got := stateNames(ts.s); !reflect.DeepEqual(got, want) {
+		t.Errorf("state has %v, want %v", got, want)
+	}
+	saved, err := state.Open(ts.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stateNames(saved); !reflect.DeepEqual(got, want) {
+		t.Errorf("saved state has %v, want %v", got, want)
+	}
+	for w := 0; w < workers; w++ {
+		name := fmt.Sprintf("a%d", w)
+		conf, err := frpc.ParseTunnelConfig(name, f.TunnelConfigPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port := conf.CreateRequest(name).LocalPort; port != 4000+w {
+			t.Errorf("%s: local_port = %d, want %d from apply", name, port, 4000+w)
+		}
+	}
+
+	staging, err := filepath.Glob(filepath.Join(f.WorkDir, ".staging-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staging) > 0 {
+		t.Errorf("staging dirs were left behind: %v", staging)
+	}
+}
+
+// TestFailedChangesRollBack makes sure a change frpc rejects, or fails to
+// reload, leaves conf.d and the state store as they were.
+func TestFailedChangesRollBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		marker  string
+		message string
+		reloads int
+	}{
+		// verify runs against the staging dir, so conf.d is never touched
+		{name: "verify", marker: "fail-verify", message: "failed to verify", reloads: 0},
+		// the staged conf.d is swapped back and reloaded again
+		{name: "reload", marker: "fail-reload", message: "failed to reload", reloads: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, f := newFrpcTestServer(t)
+			if status, resp := ts.do("POST", "/api/tunnel", webTunnel); status != http.StatusOK {
+				t.Fatalf("create web: status = %d: %s", status, resp.Error)
+			}
+			before, err := os.ReadFile(f.TunnelConfigPath("web"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(f.WorkDir, tt.marker), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(filepath.Join(f.WorkDir, "frpc.log")); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, req := range []struct{ method, path, body string }{
+				{"POST", "/api/tunnel", dbTunnel},
+				{"PATCH", "/api/tunnel/web", `{"local_port":9090}`},
+				{"DELETE", "/api/tunnel/web", ""},
+				{"POST", "/api/apply", `{"tunnels":[` + dbTunnel + `],"prune":true}`},
+				{"POST", "/api/tunnels/batch", `{"operations":[{"action":"create","tunnel":` + dbTunnel + `},{"action":"delete","name":"web"}]}`},
+			} {
+				status, resp := ts.do(req.method, req.path, req.body)
+				// batches add that none of their operations were applied
+				if status != http.StatusInternalServerError || !strings.HasPrefix(resp.Error, tt.message) {
+					t.Fatalf("%s %s: status = %d, error = %q, want %d %q...",
+						req.method, req.path, status, resp.Error, http.StatusInternalServerError, tt.message)
+				}
+			}
+
+			if got := confDNames(t, f.WorkDir); !reflect.DeepEqual(got, []string{"web"}) {
+				t.Fatalf("conf.d has %v, want [web]", got)
+			}
+			after, err := os.ReadFile(f.TunnelConfigPath("web"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(after) != string(before) {
+				t.Fatalf("web was changed to\n%s", after)
+			}
+			if got := stateNames(ts.s); !reflect.DeepEqual(got, []string{"web"}) {
+				t.Fatalf("state has %v, want [web]", got)
+			}
+
+			log, err := os.ReadFile(filepath.Join(f.WorkDir, "frpc.log"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if reloads := strings.Count(string(log), "reload\n"); reloads != 5*tt.reloads {
+				t.Fatalf("frpc was reloaded %d times, want %d", reloads, 5*tt.reloads)
+			}
+		})
+	}
+}
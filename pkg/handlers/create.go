@@ -1,96 +1,64 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
-	"text/template"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
-	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
 	"github.com/google/uuid"
 )
 
-const httpTunnelTemplate = `[{{ .Name }}]
-type = {{ .Type }}
-local_ip = {{ .LocalIP }}
-local_port = {{ .LocalPort }}
-subdomain = TBD
-meta_proxy_id = {{ .ProxyID }}
-`
-
-const tcpUdpTunnelTemplate = `[{{ .Name }}]
-type = {{ .Type }}
-local_ip = {{ .LocalIP }}
-local_port = {{ .LocalPort }}
-remote_port = {{ .RemotePort }}
-meta_proxy_id = {{ .ProxyID }}
-`
+// isSecretTunnelType reports whether the tunnel type is only reachable
+// through a visitor holding the tunnel's secret key.
+func isSecretTunnelType(tunnelType string) bool {
+	return tunnelType == "stcp" || tunnelType == "xtcp" || tunnelType == "sudp"
+}
 
-func HandleCreate(f *frpc.Frpc) func(w http.ResponseWriter, r *http.Request) {
+func HandleCreate(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var createRequest api.CreateRequest
 		if err := json.NewDecoder(r.Body).Decode(&createRequest); err != nil {
-			log.Printf("failed to decode request body: %s", err)
+			slog.ErrorContext(r.Context(), "failed to decode request body", "err", err)
 			api.RespondWithError(w, http.StatusBadRequest, "failed to decode request body")
 			return
 		}
 
-		tunnelConfigPath := filepath.Join(f.WorkDir, "conf.d", createRequest.Name+".ini")
-		if _, err := os.Stat(tunnelConfigPath); err == nil {
-			log.Printf("tunnel already exists: %s", createRequest.Name)
-			api.RespondWithError(w, http.StatusConflict, fmt.Sprintf("tunnel already exists: %s", createRequest.Name))
+		if err := validateCreateRequest(&createRequest); err != nil {
+			slog.WarnContext(r.Context(), "invalid create request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		createRequest.ProxyID = uuid.New().String()
-
-		var tunnelConfigTemplate *template.Template
-		switch createRequest.Type {
-		case "http", "https":
-			tunnelConfigTemplate = template.Must(template.New("tunnel").Parse(httpTunnelTemplate))
-		case "tcp", "udp":
-			tunnelConfigTemplate = template.Must(template.New("tunnel").Parse(tcpUdpTunnelTemplate))
-		default:
-			log.Printf("invalid tunnel type: %s", createRequest.Type)
-			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid tunnel type: %s", createRequest.Type))
+		if _, err := b.Tunnel(createRequest.Name); err == nil {
+			slog.WarnContext(r.Context(), "tunnel already exists", "tunnel", createRequest.Name)
+			api.RespondWithError(w, http.StatusConflict, fmt.Sprintf("tunnel already exists: %s", createRequest.Name))
 			return
-		}
-
-		tunnelConfig := &bytes.Buffer{}
-		if err := tunnelConfigTemplate.Execute(tunnelConfig, createRequest); err != nil {
-			log.Printf("failed to execute template: %s", err)
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to execute template")
+		} else if !os.IsNotExist(err) {
+			slog.ErrorContext(r.Context(), "failed to read tunnel config", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to read tunnel config")
 			return
 		}
 
-		if err := os.WriteFile(tunnelConfigPath, tunnelConfig.Bytes(), 0600); err != nil {
-			log.Printf("failed to write tunnel config: %s", err)
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to write tunnel config")
-			return
-		}
+		createRequest.ProxyID = uuid.New().String()
 
-		if _, err := f.Output("verify"); err != nil {
-			log.Printf("failed to verify: %s", err)
-			if err := os.Remove(tunnelConfigPath); err != nil {
-				log.Printf("failed to delete tunnel config file: %s", err)
-			}
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to verify")
+		if message, err := applyTunnels(b, backend.TunnelChanges{createRequest.Name: {Proxy: &createRequest}}); err != nil {
+			slog.ErrorContext(r.Context(), message, "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, message)
 			return
 		}
 
-		if _, err := f.Output("reload"); err != nil {
-			log.Printf("failed to reload: %s", err)
-			if err := os.Remove(tunnelConfigPath); err != nil {
-				log.Printf("failed to delete tunnel config file: %s", err)
-			}
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to reload")
+		if err := createMetadata(s, createRequest.Name, newMetadata(&createRequest, requestCreator(r))); err != nil {
+			slog.ErrorContext(r.Context(), "failed to save metadata", "tunnel", createRequest.Name, "err", err)
+			revertTunnels(b, backend.TunnelChanges{createRequest.Name: nil})
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to save tunnel metadata, the tunnel was not created")
 			return
 		}
+		publishTunnelEvents(b, api.EventTunnelCreated, createRequest.Name)
 
 		api.RespondWithSuccess(w, "tunnel created")
 	}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestCreateApplyAndUpdateAgree makes sure every endpoint that writes a
+// tunnel passes the same tunnel to the backend, so apply sees a tunnel
+// created or updated elsewhere as unchanged.
+func TestCreateApplyAndUpdateAgree(t *testing.T) {
+	ts := newTestServer(t)
+
+	tunnel := `{"name":"web","type":"http","local_ip":"127.0.0.1","local_port":8080,"custom_domains":["example.com"],"use_encryption":true}`
+	if status, resp := ts.do("POST", "/api/tunnel", tunnel); status != http.StatusOK {
+		t.Fatalf("create: status = %d: %s", status, resp.Error)
+	}
+	created, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := ts.do("POST", "/api/apply", `{"tunnels":[`+tunnel+`]}`)
+	if status != http.StatusOK {
+		t.Fatalf("apply: status = %d: %s", status, resp.Error)
+	}
+	if resp.Message != "0 created, 0 updated, 0 deleted, 1 unchanged" {
+		t.Fatalf("apply: %s", resp.Message)
+	}
+
+	if status, resp := ts.do("PATCH", "/api/tunnel/web", `{"local_port":8080}`); status != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", status, resp.Error)
+	}
+	updated, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, created) {
+		t.Fatalf("update wrote %+v, want %+v", updated.Proxy, created.Proxy)
+	}
+}
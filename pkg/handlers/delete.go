@@ -1,62 +1,53 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
-	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
 	"github.com/gorilla/mux"
 )
 
-func HandleDelete(f *frpc.Frpc) func(w http.ResponseWriter, r *http.Request) {
+func HandleDelete(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		tunnelName := vars["name"]
 
-		// delete file, reload, and restore if failed
-		tunnelConfigPath := filepath.Join(f.WorkDir, "conf.d", tunnelName+".ini")
-		if _, err := os.Stat(tunnelConfigPath); err != nil {
-			log.Printf("tunnel does not exist: %s", tunnelName)
-			api.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("tunnel does not exist: %s", tunnelName))
+		if err := deleteTunnel(b, s, tunnelName); err != nil {
+			if os.IsNotExist(err) {
+				slog.WarnContext(r.Context(), "tunnel does not exist", "tunnel", tunnelName)
+				api.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("tunnel does not exist: %s", tunnelName))
+				return
+			}
+			slog.ErrorContext(r.Context(), "failed to delete tunnel", "tunnel", tunnelName, "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		tunnelConfig, err := ioutil.ReadFile(tunnelConfigPath)
-		if err != nil {
-			log.Printf("failed to read tunnel config file: %s", err)
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to read tunnel config file")
-			return
-		}
+		api.RespondWithSuccess(w, "tunnel deleted")
+	}
+}
 
-		if err := os.Remove(tunnelConfigPath); err != nil {
-			log.Printf("failed to delete tunnel config file: %s", err)
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to delete tunnel config file")
-			return
-		}
+// deleteTunnel removes the tunnel config through the backend, then its
+// metadata. If the tunnel does not exist, the returned error satisfies
+// os.IsNotExist. The caller must hold the config lock.
+func deleteTunnel(b backend.Backend, s *state.Store, tunnelName string) error {
+	if _, err := b.Tunnel(tunnelName); err != nil {
+		return err
+	}
 
-		if _, err = f.Output("verify"); err != nil {
-			log.Printf("failed to verify: %s", err)
-			if err := os.Remove(tunnelConfigPath); err != nil {
-				log.Printf("failed to delete tunnel config file: %s", err)
-			}
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to verify")
-			return
-		}
+	if message, err := applyTunnels(b, backend.TunnelChanges{tunnelName: nil}); err != nil {
+		slog.Error(message, "err", err)
+		return errors.New(message)
+	}
 
-		if _, err = f.Output("reload"); err != nil {
-			log.Printf("failed to reload: %s", err)
-			if err := ioutil.WriteFile(tunnelConfigPath, tunnelConfig, 0600); err != nil {
-				log.Printf("failed to restore tunnel config file: %s", err)
-			}
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to reload")
-			return
-		}
+	deleteMetadata(s, tunnelName)
+	publishTunnelEvents(b, api.EventTunnelDeleted, tunnelName)
 
-		api.RespondWithSuccess(w, "tunnel deleted")
-	}
+	return nil
 }
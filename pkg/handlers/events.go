@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+)
+
+// heartbeatInterval keeps idle event streams from being closed by proxies.
+const heartbeatInterval = 15 * time.Second
+
+// HandleEvents returns recent events, or with ?follow=true streams them as
+// server-sent events until the client disconnects. A client reconnecting
+// with a Last-Event-ID header is sent the recent events it missed.
+func HandleEvents(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("follow") != "true" {
+			api.RespondWithData(w, "", &api.EventsResponse{Events: b.Events().History()})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			slog.ErrorContext(r.Context(), "streaming not supported")
+			api.RespondWithError(w, http.StatusInternalServerError, "streaming not supported")
+			return
+		}
+
+		var lastID uint64
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			lastID, _ = strconv.ParseUint(id, 10, 64)
+		}
+
+		// subscribe before reading the history so nothing is missed in between
+		ch, unsubscribe := b.Events().Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		if lastID > 0 {
+			for _, e := range b.Events().History() {
+				if e.ID <= lastID {
+					continue
+				}
+				if err := writeEvent(w, e); err != nil {
+					return
+				}
+				lastID = e.ID
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case e := <-ch:
+				if e.ID <= lastID {
+					// already sent from the history
+					continue
+				}
+				if err := writeEvent(w, e); err != nil {
+					return
+				}
+				lastID = e.ID
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e api.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+	return err
+}
+
+// publishTunnelEvents publishes an event of the given type for each tunnel.
+func publishTunnelEvents(b backend.Backend, eventType string, names ...string) {
+	for _, name := range names {
+		b.Events().Publish(api.Event{Type: eventType, Tunnel: name})
+	}
+}
+
+// StartStatusWatcher polls the backend every interval and publishes an
+// event whenever a proxy's status or error changes, or the proxy goes
+// away. It only polls while someone is subscribed to events. The
+// returned func stops it.
+func StartStatusWatcher(b backend.Backend, interval time.Duration) (stop func()) {
+	last := make(map[string]api.ProxyStatus)
+	return startLoop(interval, func() {
+		if b.Events().Subscribers() == 0 || !b.Running() {
+			// report the current status again once polling resumes
+			last = make(map[string]api.ProxyStatus)
+			return
+		}
+		last = watchStatus(b, last)
+	})
+}
+
+func watchStatus(b backend.Backend, last map[string]api.ProxyStatus) map[string]api.ProxyStatus {
+	statuses, err := proxyStatuses(b)
+	if err != nil {
+		// frpc may be restarting, try again on the next tick
+		return last
+	}
+
+	current := make(map[string]api.ProxyStatus)
+	for name, ps := range statuses {
+		status := api.ProxyStatus{Name: name, Status: ps.Status, Error: ps.Error}
+		current[name] = status
+		if previous, ok := last[name]; ok && previous == status {
+			continue
+		}
+		b.Events().Publish(api.Event{
+			Type:    api.EventTunnelStatus,
+			Tunnel:  name,
+			Status:  ps.Status,
+			Message: ps.Error,
+		})
+	}
+
+	for name := range last {
+		if _, ok := current[name]; !ok {
+			b.Events().Publish(api.Event{Type: api.EventTunnelRemoved, Tunnel: name})
+		}
+	}
+
+	return current
+}
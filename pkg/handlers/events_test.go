@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+)
+
+func TestWatchStatus(t *testing.T) {
+	ts := newTestServer(t)
+	if status, resp := ts.do("POST", "/api/tunnel", webTunnel); status != http.StatusOK {
+		t.Fatalf("create web: status = %d: %s", status, resp.Error)
+	}
+
+	events, unsubscribe := ts.b.Events().Subscribe()
+	defer unsubscribe()
+	next := func() api.Event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		default:
+			t.Fatal("no event was published")
+			return api.Event{}
+		}
+	}
+
+	last := watchStatus(ts.b, map[string]api.ProxyStatus{})
+	if e := next(); e.Type != api.EventTunnelStatus || e.Tunnel != "web" || e.Status != "running" {
+		t.Fatalf("event = %+v, want web running", e)
+	}
+
+	ts.b.SetStatus("web", api.ProxyStatus{Status: "check failed", Error: "connection refused"})
+	last = watchStatus(ts.b, last)
+	if e := next(); e.Type != api.EventTunnelStatus || e.Status != "check failed" || e.Message != "connection refused" {
+		t.Fatalf("event = %+v, want web check failed", e)
+	}
+
+	last = watchStatus(ts.b, last)
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %+v, the status didn't change", e)
+	default:
+	}
+
+	if status, resp := ts.do("DELETE", "/api/tunnel/web", ""); status != http.StatusOK {
+		t.Fatalf("delete web: status = %d: %s", status, resp.Error)
+	}
+	if e := next(); e.Type != api.EventTunnelDeleted {
+		t.Fatalf("event = %+v, want web deleted", e)
+	}
+	last = watchStatus(ts.b, last)
+	if e := next(); e.Type != api.EventTunnelRemoved || e.Tunnel != "web" {
+		t.Fatalf("event = %+v, want web removed", e)
+	}
+	if len(last) != 0 {
+		t.Fatalf("last = %v, want no proxies", last)
+	}
+}
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+)
+
+// HandleFrpc reports the frpc process and its recent exits. It works
+// while frpc is down, which is when it is most useful.
+func HandleFrpc(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api.RespondWithData(w, "", b.Supervisor())
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/gorilla/mux"
+)
+
+func HandleGet(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		tunnelName := vars["name"]
+
+		t, err := b.Tunnel(tunnelName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				slog.WarnContext(r.Context(), "tunnel does not exist", "tunnel", tunnelName)
+				api.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("tunnel does not exist: %s", tunnelName))
+				return
+			}
+			slog.ErrorContext(r.Context(), "failed to read tunnel config", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to read tunnel config")
+			return
+		}
+
+		// without the statuses, the tunnel's status is unknown
+		statuses, err := proxyStatuses(b)
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to get tunnel status", "tunnel", tunnelName, "err", err)
+		}
+
+		tunnel := newTunnel(tunnelName, t, statuses)
+		if m, ok := s.Get(tunnelName); ok {
+			setTunnelMetadata(&tunnel, m)
+		}
+		api.RespondWithData(w, fmt.Sprintf("tunnel %s", tunnelName), &tunnel)
+	}
+}
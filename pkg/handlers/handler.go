@@ -1,52 +1,95 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
-	"github.com/cbodonnell/tfarm/pkg/auth"
-	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/cbodonnell/tfarm/pkg/stats"
 	"github.com/gorilla/mux"
 )
 
-func NewMuxHandler(f *frpc.Frpc) http.Handler {
+// NewMuxHandler returns the tfarmd api. rec is nil when traffic stats are
+// not enabled.
+func NewMuxHandler(b backend.Backend, s *state.Store, rec *stats.Recorder) http.Handler {
 	r := mux.NewRouter()
+	r.Use(logMiddleware, instrumentMiddleware)
 
 	// pre-configure routes
 	preConfigure := r.NewRoute().Subrouter()
 	preConfigure.HandleFunc("/api/info", HandleInfo()).Methods("GET")
-	preConfigure.HandleFunc("/api/configure", HandleConfigure(f)).Methods("PUT")
+	preConfigure.HandleFunc("/healthz", HandleHealthz()).Methods("GET")
+	preConfigure.HandleFunc("/readyz", HandleReadyz(b)).Methods("GET")
+	preConfigure.HandleFunc("/api/configure", withConfigLock(b, HandleConfigure(b))).Methods("PUT")
+	// events are available before configuring so clients can watch it happen
+	preConfigure.HandleFunc("/api/events", HandleEvents(b)).Methods("GET")
+	preConfigure.HandleFunc("/api/frpc", HandleFrpc(b)).Methods("GET")
 
 	// post-configure routes
 	postConfigure := r.NewRoute().Subrouter()
-	postConfigure.HandleFunc("/api/status", HandleStatus(f)).Methods("GET")
-	postConfigure.HandleFunc("/api/verify", HandleVerify(f)).Methods("GET")
-	postConfigure.HandleFunc("/api/reload", HandleReload(f)).Methods("POST")
-	postConfigure.HandleFunc("/api/restart", HandleRestart(f)).Methods("POST")
-	postConfigure.HandleFunc("/api/tunnel", HandleCreate(f)).Methods("POST")
-	postConfigure.HandleFunc("/api/tunnel/{name}", HandleDelete(f)).Methods("DELETE")
-	postConfigure.Use(isConfiguredMiddleware, isCmdMiddlware(f))
+	postConfigure.HandleFunc("/api/status", HandleStatus(b)).Methods("GET")
+	postConfigure.HandleFunc("/api/verify", withConfigRLock(b, HandleVerify(b))).Methods("GET")
+	postConfigure.HandleFunc("/api/reload", withConfigLock(b, HandleReload(b))).Methods("POST")
+	postConfigure.HandleFunc("/api/restart", withConfigLock(b, HandleRestart(b))).Methods("POST")
+	postConfigure.HandleFunc("/api/tunnels", withConfigRLock(b, HandleList(b, s))).Methods("GET")
+	postConfigure.HandleFunc("/api/stats", HandleStats(rec)).Methods("GET")
+	postConfigure.HandleFunc("/api/tunnels/batch", withConfigLock(b, HandleBatch(b, s))).Methods("POST")
+	postConfigure.HandleFunc("/api/tunnel", withWait(b, withConfigLock(b, HandleCreate(b, s)))).Methods("POST")
+	postConfigure.HandleFunc("/api/visitor", withConfigLock(b, HandleCreateVisitor(b, s))).Methods("POST")
+	postConfigure.HandleFunc("/api/apply", withConfigLock(b, HandleApply(b, s))).Methods("POST")
+	postConfigure.HandleFunc("/api/tunnel/{name}", withConfigRLock(b, HandleGet(b, s))).Methods("GET")
+	postConfigure.HandleFunc("/api/tunnel/{name}", withConfigLock(b, HandleUpdate(b, s))).Methods("PATCH")
+	postConfigure.HandleFunc("/api/tunnel/{name}", withConfigLock(b, HandleDelete(b, s))).Methods("DELETE")
+	postConfigure.HandleFunc("/api/tunnel/{name}/stats", withConfigRLock(b, HandleTunnelStats(b, rec))).Methods("GET")
+	postConfigure.HandleFunc("/api/tunnel/{name}/wait", HandleWait(b)).Methods("GET")
+	postConfigure.HandleFunc("/api/tunnel/{name}/renew", withConfigRLock(b, HandleRenew(s))).Methods("POST")
+	postConfigure.Use(isConfiguredMiddleware(b), isCmdMiddlware(b))
 
 	return r
 }
 
-func isConfiguredMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !auth.IsConfigured() {
-			log.Printf("not configured")
-			api.RespondWithError(w, http.StatusUnauthorized, "tfarmd not configured. run `tfarmd configure`")
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// withConfigLock runs handlers that change the tunnels or the backend one
+// at a time, so their verify and reload calls can't interleave.
+func withConfigLock(b backend.Backend, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b.Lock()
+		defer b.Unlock()
+		next(w, r)
+	}
+}
+
+// withConfigRLock lets handlers that only read tunnels run concurrently
+// with each other, but not with changes.
+func withConfigRLock(b backend.Backend, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b.RLock()
+		defer b.RUnlock()
+		next(w, r)
+	}
 }
 
-func isCmdMiddlware(f *frpc.Frpc) func(next http.Handler) http.Handler {
+func isConfiguredMiddleware(b backend.Backend) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !f.IsCmd() {
-				log.Printf("frpc not running")
+			if !b.Configured() {
+				slog.WarnContext(r.Context(), "not configured")
+				api.RespondWithError(w, http.StatusUnauthorized, "tfarmd not configured. run `tfarmd configure`")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isCmdMiddlware(b backend.Backend) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !b.Running() {
+				slog.WarnContext(r.Context(), "frpc not running")
 				api.RespondWithError(w, http.StatusUnauthorized, "frpc not running. check tfarm server logs for more information")
 				return
 			}
@@ -54,3 +97,30 @@ func isCmdMiddlware(f *frpc.Frpc) func(next http.Handler) http.Handler {
 		})
 	}
 }
+
+// startLoop calls fn every interval until the returned func is called.
+// Stopping waits for a call in progress to return, so fn doesn't run once
+// its backend is stopped.
+func startLoop(interval time.Duration, fn func()) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+)
+
+// testServer serves the api against an in-memory backend.
+type testServer struct {
+	t         *testing.T
+	srv       *httptest.Server
+	b         *backend.Fake
+	s         *state.Store
+	statePath string
+}
+
+// newTestServer serves the api against an in-memory backend that is
+// configured and running.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	b := backend.NewFake(true)
+	b.Start()
+
+	statePath := filepath.Join(t.TempDir(), "tunnels.json")
+	s, err := state.Open(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewMuxHandler(b, s, nil))
+	t.Cleanup(srv.Close)
+
+	return &testServer{t: t, srv: srv, b: b, s: s, statePath: statePath}
+}
+
+// do sends a request with a json body and decodes the response envelope.
+func (ts *testServer) do(method, path, body string) (int, *api.Response) {
+	t := ts.t
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ts.srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &api.Response{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		t.Fatalf("%s %s: invalid response %q: %s", method, path, b, err)
+	}
+
+	return res.StatusCode, resp
+}
+
+// failStateSaves makes every later save of the state store fail, by putting
+// a directory where the state file is renamed to. The store keeps what it
+// loaded in memory.
+func (ts *testServer) failStateSaves() {
+	ts.t.Helper()
+	if err := os.Remove(ts.statePath); err != nil && !os.IsNotExist(err) {
+		ts.t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(ts.statePath, "blocked"), 0755); err != nil {
+		ts.t.Fatal(err)
+	}
+}
+
+// TestStartLoopStops makes sure stopping a loop waits for the call in
+// progress and that no call starts after it.
+func TestStartLoopStops(t *testing.T) {
+	var calls, returned atomic.Int32
+	started := make(chan struct{}, 1)
+	stop := startLoop(time.Millisecond, func() {
+		calls.Add(1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		time.Sleep(20 * time.Millisecond)
+		returned.Add(1)
+	})
+
+	<-started
+	stop()
+	n := calls.Load()
+	if returned.Load() != n {
+		t.Fatal("stop returned while fn was running")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls.Load() != n {
+		t.Fatalf("fn was called %d times after stopping", calls.Load()-n)
+	}
+	// stopping again is a no-op
+	stop()
+}
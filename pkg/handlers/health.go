@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/gorilla/mux"
+)
+
+// NewHealthHandler serves only the health and readiness probes, for a
+// listener without client certificates.
+func NewHealthHandler(b backend.Backend) http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", HandleHealthz()).Methods("GET")
+	r.HandleFunc("/readyz", HandleReadyz(b)).Methods("GET")
+	return r
+}
+
+// HandleHealthz reports that the tfarmd process is alive.
+func HandleHealthz() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api.RespondWithData(w, "", &api.HealthResponse{Status: "ok"})
+	}
+}
+
+// HandleReadyz reports whether tfarmd can serve tunnels: it is configured,
+// frpc is running and not crash looping, its admin api answers and it is
+// logged in to frps.
+// Each check is reported, and the status is 503 if any fails. It doesn't
+// take the config lock, so probes still answer while a change is applied.
+func HandleReadyz(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := &api.HealthResponse{Status: "ok"}
+		check := func(name string, ok bool, message string) {
+			res.Checks = append(res.Checks, api.HealthCheck{Name: name, OK: ok, Message: message})
+			if !ok {
+				res.Status = "unavailable"
+			}
+		}
+
+		if b.Configured() {
+			check("configured", true, "")
+		} else {
+			check("configured", false, "tfarmd not configured. run `tfarmd configure`")
+		}
+
+		stats := b.Stats()
+		if stats.StartedAt.IsZero() {
+			check("frpc", false, "frpc not running")
+		} else {
+			check("frpc", true, "")
+		}
+
+		if sup := b.Supervisor(); sup.Degraded {
+			check("frpc_crash_loop", false, fmt.Sprintf("frpc failed %d times in a row", sup.ConsecutiveFailures))
+		} else {
+			check("frpc_crash_loop", true, "")
+		}
+
+		if _, err := b.Status(); err != nil {
+			check("frpc_admin", false, err.Error())
+		} else {
+			check("frpc_admin", true, "")
+		}
+
+		// frpc logs in again whenever it reconnects, so a login since it
+		// started and since it last lost the connection means it is
+		// connected to frps
+		if stats.LastLogin.IsZero() || stats.LastLogin.Before(stats.StartedAt) {
+			check("frps", false, "frpc has not logged in to frps")
+		} else if !stats.LastLogin.After(stats.LastDisconnect) {
+			check("frps", false, fmt.Sprintf("frpc lost its connection to frps at %s", stats.LastDisconnect.Format(time.RFC3339)))
+		} else {
+			check("frps", true, "")
+		}
+
+		if res.Status != "ok" {
+			slog.DebugContext(r.Context(), "not ready", "checks", res.Checks)
+			api.RespondWithErrorData(w, http.StatusServiceUnavailable, "tfarmd not ready", res)
+			return
+		}
+		api.RespondWithData(w, "tfarmd ready", res)
+	}
+}
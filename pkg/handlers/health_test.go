@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// readyz returns the status and the checks that failed.
+func readyz(t *testing.T, ts *testServer) (int, []string) {
+	t.Helper()
+	status, resp := ts.do("GET", "/readyz", "")
+	data, ok := resp.Data.(map[string]interface{})
+	if !ok {
+		t.Fatalf("readyz: no checks in %+v", resp)
+	}
+	var failed []string
+	for _, c := range data["checks"].([]interface{}) {
+		check := c.(map[string]interface{})
+		if ok, _ := check["ok"].(bool); !ok {
+			failed = append(failed, check["name"].(string))
+		}
+	}
+	return status, failed
+}
+
+func TestReadyzFrpsConnection(t *testing.T) {
+	ts := newTestServer(t)
+	if status, failed := readyz(t, ts); status != http.StatusOK {
+		t.Fatalf("status = %d, failed checks %v", status, failed)
+	}
+
+	ts.b.Disconnect()
+	status, failed := readyz(t, ts)
+	if status != http.StatusServiceUnavailable || len(failed) != 1 || failed[0] != "frps" {
+		t.Fatalf("status = %d, failed checks %v, want only frps to fail", status, failed)
+	}
+
+	// frpc logs in again when it reconnects
+	if err := ts.b.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if status, failed := readyz(t, ts); status != http.StatusOK {
+		t.Fatalf("status = %d, failed checks %v", status, failed)
+	}
+}
+
+func TestReadyzDoesNotWaitForConfigLock(t *testing.T) {
+	ts := newTestServer(t)
+	ts.b.Lock()
+	defer ts.b.Unlock()
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		HandleReadyz(ts.b)(rec, httptest.NewRequest("GET", "/readyz", nil))
+		done <- rec.Code
+	}()
+
+	select {
+	case status := <-done:
+		if status != http.StatusOK {
+			t.Fatalf("status = %d, want %d", status, http.StatusOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readyz blocked on the config lock")
+	}
+}
@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
@@ -14,12 +14,13 @@ func HandleInfo() func(w http.ResponseWriter, r *http.Request) {
 		info := &api.ServerInfoResponse{
 			Version: version.Version,
 		}
+		// the message carries the json encoded info for clients that predate the data field
 		output, err := json.Marshal(info)
 		if err != nil {
-			log.Printf("failed to marshal info: %s", err)
+			slog.ErrorContext(r.Context(), "failed to marshal info", "err", err)
 			api.RespondWithError(w, http.StatusInternalServerError, "failed to marshal info")
 			return
 		}
-		api.RespondWithSuccess(w, string(output))
+		api.RespondWithData(w, string(output), info)
 	}
 }
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+)
+
+func HandleList(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		selector, err := parseSelector(r.URL.Query().Get("selector"))
+		if err != nil {
+			slog.WarnContext(r.Context(), "invalid selector", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		all, err := listTunnels(b, s)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to list tunnels", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to list tunnels")
+			return
+		}
+
+		tunnels := make([]api.Tunnel, 0, len(all))
+		for _, t := range all {
+			if selector.Matches(t.Labels) {
+				tunnels = append(tunnels, t)
+			}
+		}
+		api.RespondWithData(w, fmt.Sprintf("%d tunnels", len(tunnels)), &api.TunnelsResponse{Tunnels: tunnels})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// TestListAndGetWithoutStatus makes sure the tunnels are still served,
+// with an unknown status, when the backend can't report their status.
+func TestListAndGetWithoutStatus(t *testing.T) {
+	ts := newTestServer(t)
+	for _, tunnel := range []string{webTunnel, dbTunnel} {
+		if status, resp := ts.do("POST", "/api/tunnel", tunnel); status != http.StatusOK {
+			t.Fatalf("create: status = %d: %s", status, resp.Error)
+		}
+	}
+	ts.b.FailStatus(errors.New("admin api unreachable"))
+
+	status, resp := ts.do("GET", "/api/tunnels", "")
+	if status != http.StatusOK || resp.Message != "2 tunnels" {
+		t.Fatalf("list: status = %d: %s%s", status, resp.Message, resp.Error)
+	}
+	for _, tunnel := range resp.Data.(map[string]interface{})["tunnels"].([]interface{}) {
+		if tunnel := tunnel.(map[string]interface{}); tunnel["status"] != "unknown" {
+			t.Fatalf("list: %s has status %v, want unknown", tunnel["name"], tunnel["status"])
+		}
+	}
+
+	status, resp = ts.do("GET", "/api/tunnel/web", "")
+	if status != http.StatusOK {
+		t.Fatalf("get: status = %d: %s", status, resp.Error)
+	}
+	if tunnel := resp.Data.(map[string]interface{}); tunnel["name"] != "web" || tunnel["status"] != "unknown" {
+		t.Fatalf("get: %v has status %v, want web with unknown", tunnel["name"], tunnel["status"])
+	}
+
+	// the status is back as soon as the backend reports it again
+	ts.b.FailStatus(nil)
+	status, resp = ts.do("GET", "/api/tunnel/web", "")
+	if status != http.StatusOK || resp.Data.(map[string]interface{})["status"] != "running" {
+		t.Fatalf("get: status = %d, data = %v, want running once the status is back", status, resp.Data)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/logging"
+	"github.com/gorilla/mux"
+)
+
+// logMiddleware logs each request once it completes, and adds the route,
+// method and client to everything handlers log with the request context.
+// Successful reads are logged at debug level since clients poll them.
+func logMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attrs := []any{"route", routeTemplate(r), "method", r.Method}
+		if client := requestCreator(r); client != "" {
+			attrs = append(attrs, "client", client)
+		}
+		r = r.WithContext(logging.WithAttrs(r.Context(), attrs...))
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		case r.Method == http.MethodGet:
+			level = slog.LevelDebug
+		}
+		slog.Log(r.Context(), level, "request", "status", rec.status, "latency", time.Since(start))
+	})
+}
+
+// routeTemplate returns the path template of the matched route, so tunnel
+// names don't each show up as a different route.
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tmpl, err := current.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+)
+
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_./-]*[a-zA-Z0-9])?$`)
+	labelValueRegexp = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9_.-]*[a-zA-Z0-9])?)?$`)
+)
+
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			return fmt.Errorf("invalid label key: %q", k)
+		}
+		if !labelValueRegexp.MatchString(v) {
+			return fmt.Errorf("invalid value for label %s: %q", k, v)
+		}
+	}
+	return nil
+}
+
+// labelRequirement is a single key=value or key!=value term of a selector.
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+}
+
+// labelSelector matches tunnels whose labels meet all requirements.
+type labelSelector []labelRequirement
+
+// parseSelector parses a comma-separated list of label requirements,
+// e.g. env=preview,team!=infra.
+func parseSelector(selector string) (labelSelector, error) {
+	var sel labelSelector
+	if selector == "" {
+		return sel, nil
+	}
+
+	for _, term := range strings.Split(selector, ",") {
+		var req labelRequirement
+		if k, v, ok := strings.Cut(term, "!="); ok {
+			req = labelRequirement{key: k, value: v, negate: true}
+		} else if k, v, ok := strings.Cut(term, "="); ok {
+			req = labelRequirement{key: k, value: v}
+		} else {
+			return nil, fmt.Errorf("invalid selector term: %q, must be key=value or key!=value", term)
+		}
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+		if !labelKeyRegexp.MatchString(req.key) {
+			return nil, fmt.Errorf("invalid label key in selector: %q", req.key)
+		}
+		sel = append(sel, req)
+	}
+
+	return sel, nil
+}
+
+func (sel labelSelector) Matches(labels map[string]string) bool {
+	for _, req := range sel {
+		if (labels[req.key] == req.value) == req.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// requestCreator returns the common name of the client certificate the
+// request was made with.
+func requestCreator(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// newMetadata returns the metadata requested for a new tunnel.
+func newMetadata(req *api.CreateRequest, creator string) state.Metadata {
+	m := state.Metadata{
+		Creator:     creator,
+		Labels:      req.Labels,
+		Description: req.Description,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	// the ttl and lease were validated with the rest of the request
+	if ttl, err := time.ParseDuration(req.TTL); err == nil {
+		expiresAt := time.Now().UTC().Add(ttl)
+		m.ExpiresAt = &expiresAt
+	}
+	if lease, err := time.ParseDuration(req.Lease); err == nil {
+		expiresAt := time.Now().UTC().Add(lease)
+		m.ExpiresAt = &expiresAt
+		m.Lease = req.Lease
+	}
+	return m
+}
+
+// metadataChanges collects the metadata changes that go with a set of
+// tunnel changes, so they are saved, or fail to save, as one.
+type metadataChanges struct {
+	s      *state.Store
+	now    time.Time
+	put    map[string]state.Metadata
+	remove []string
+}
+
+func newMetadataChanges(s *state.Store) *metadataChanges {
+	return &metadataChanges{
+		s:   s,
+		now: time.Now().UTC(),
+		put: make(map[string]state.Metadata),
+	}
+}
+
+// create records the metadata of a newly created tunnel.
+func (c *metadataChanges) create(name string, m state.Metadata) {
+	m.CreatedAt = c.now
+	m.UpdatedAt = c.now
+	c.put[name] = m
+}
+
+// update bumps the updated_at of a tunnel after applying update to its
+// metadata. update is passed the time of the change.
+func (c *metadataChanges) update(name string, update func(m *state.Metadata, now time.Time)) {
+	m, ok := c.s.Get(name)
+	if !ok {
+		// the tunnel was created before metadata was recorded
+		m.CreatedAt = c.now
+	}
+	m.UpdatedAt = c.now
+	update(&m, c.now)
+	c.put[name] = m
+}
+
+func (c *metadataChanges) delete(name string) {
+	c.remove = append(c.remove, name)
+}
+
+func (c *metadataChanges) save() error {
+	if len(c.put) == 0 && len(c.remove) == 0 {
+		return nil
+	}
+	return c.s.Update(c.put, c.remove)
+}
+
+// createMetadata records the metadata of a newly created tunnel.
+func createMetadata(s *state.Store, name string, m state.Metadata) error {
+	c := newMetadataChanges(s)
+	c.create(name, m)
+	return c.save()
+}
+
+// updateMetadata bumps the updated_at of a tunnel after applying update
+// to its metadata.
+func updateMetadata(s *state.Store, name string, update func(m *state.Metadata, now time.Time)) error {
+	c := newMetadataChanges(s)
+	c.update(name, update)
+	return c.save()
+}
+
+// revertTunnels undoes tunnel changes that were applied before a later
+// step failed. previous holds the tunnels from before the changes.
+func revertTunnels(b backend.Backend, previous backend.TunnelChanges) {
+	if err := b.ApplyTunnels(previous); err != nil {
+		slog.Error("failed to revert tunnel configs", "err", err)
+	}
+}
+
+func deleteMetadata(s *state.Store, names ...string) {
+	if err := s.Delete(names...); err != nil {
+		slog.Error("failed to delete metadata", "tunnels", names, "err", err)
+	}
+}
+
+// setExpiry sets the expiry requested for an existing tunnel, replacing
+// the one it had. A ttl counts from when the tunnel was created and a
+// lease is only restarted when it changes, so asking for the same expiry
+// again leaves it as it is.
+func setExpiry(m *state.Metadata, req *api.CreateRequest, now time.Time) {
+	// the ttl and lease were validated with the rest of the request
+	switch {
+	case req.TTL != "":
+		ttl, _ := time.ParseDuration(req.TTL)
+		expiresAt := m.CreatedAt.Add(ttl)
+		m.ExpiresAt = &expiresAt
+		m.Lease = ""
+	case req.Lease != "":
+		if m.Lease != req.Lease || m.ExpiresAt == nil {
+			lease, _ := time.ParseDuration(req.Lease)
+			expiresAt := now.Add(lease)
+			m.ExpiresAt = &expiresAt
+			m.Lease = req.Lease
+		}
+	default:
+		m.ExpiresAt = req.ExpiresAt
+		m.Lease = ""
+	}
+}
+
+// updateTunnelMetadata sets the metadata of an existing tunnel to what
+// req describes.
+func updateTunnelMetadata(m *state.Metadata, req *api.CreateRequest, now time.Time) {
+	m.Labels = req.Labels
+	m.Description = req.Description
+	setExpiry(m, req, now)
+}
+
+// metadataMatches reports whether the stored labels, description and
+// expiry of the tunnel are the ones requested.
+func metadataMatches(s *state.Store, req *api.CreateRequest) bool {
+	m, _ := s.Get(req.Name)
+	if m.Description != req.Description || len(m.Labels) != len(req.Labels) {
+		return false
+	}
+	expiresAt, lease := m.ExpiresAt, m.Lease
+	setExpiry(&m, req, time.Now().UTC())
+	if m.Lease != lease || (m.ExpiresAt == nil) != (expiresAt == nil) ||
+		(expiresAt != nil && !m.ExpiresAt.Equal(*expiresAt)) {
+		return false
+	}
+	for k, v := range req.Labels {
+		if current, ok := m.Labels[k]; !ok || current != v {
+			return false
+		}
+	}
+	return true
+}
+
+// setTunnelMetadata copies the stored metadata onto the tunnel.
+func setTunnelMetadata(t *api.Tunnel, m state.Metadata) {
+	createdAt, updatedAt := m.CreatedAt, m.UpdatedAt
+	t.CreatedAt = &createdAt
+	t.UpdatedAt = &updatedAt
+	t.Creator = m.Creator
+	t.Labels = m.Labels
+	t.Description = m.Description
+	t.ExpiresAt = m.ExpiresAt
+	t.Lease = m.Lease
+}
+
+// minLease keeps clients from renewing more often than is reasonable.
+const minLease = 10 * time.Second
+
+func validateExpiry(req *api.CreateRequest) error {
+	set := 0
+	for _, ok := range []bool{req.TTL != "", req.ExpiresAt != nil, req.Lease != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("only one of ttl, expires_at and lease can be set")
+	}
+	if req.Lease != "" {
+		lease, err := time.ParseDuration(req.Lease)
+		if err != nil || lease < minLease {
+			return fmt.Errorf("invalid lease: %q, must be a duration of at least %s", req.Lease, minLease)
+		}
+	}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl: %q, must be a positive duration such as 30m or 2h", req.TTL)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	webTunnel = `{"name":"web","type":"http","local_ip":"127.0.0.1","local_port":8080}`
+	dbTunnel  = `{"name":"db","type":"tcp","local_ip":"127.0.0.1","local_port":5432,"remote_port":15432}`
+)
+
+// TestMetadataSaveFailureRollsBack makes sure a change is undone when its
+// metadata can't be saved, so no tunnel is left without its ttl or lease.
+func TestMetadataSaveFailureRollsBack(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{name: "create", path: "/api/tunnel", body: dbTunnel},
+		{name: "visitor", path: "/api/visitor", body: `{"name":"db","type":"stcp","server_name":"x","sk":"secret","bind_port":9000}`},
+		{name: "batch", path: "/api/tunnels/batch", body: `{"operations":[{"action":"create","tunnel":` + dbTunnel + `},{"action":"delete","name":"web"}]}`},
+		{name: "apply", path: "/api/apply", body: `{"tunnels":[` + dbTunnel + `],"prune":true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if status, resp := ts.do("POST", "/api/tunnel", webTunnel); status != http.StatusOK {
+				t.Fatalf("create web: status = %d: %s", status, resp.Error)
+			}
+			before, err := ts.b.Tunnels()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ts.failStateSaves()
+			if status, resp := ts.do("POST", tt.path, tt.body); status != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d: %s", status, http.StatusInternalServerError, resp.Error)
+			}
+
+			after, err := ts.b.Tunnels()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != len(before) {
+				t.Fatalf("tunnels = %d, want %d", len(after), len(before))
+			}
+			for name, tunnel := range before {
+				if !reflect.DeepEqual(after[name], tunnel) {
+					t.Fatalf("tunnel %s was not restored", name)
+				}
+			}
+			if _, err := ts.b.Tunnel("db"); !os.IsNotExist(err) {
+				t.Fatalf("tunnel db was created: %v", err)
+			}
+			if _, ok := ts.s.Get("web"); !ok {
+				t.Fatal("metadata of web was removed")
+			}
+		})
+	}
+}
+
+// TestExpiryUpdates makes sure every way of updating a tunnel sets the
+// expiry it asks for.
+func TestExpiryUpdates(t *testing.T) {
+	ts := newTestServer(t)
+	if status, resp := ts.do("POST", "/api/tunnel", webTunnel); status != http.StatusOK {
+		t.Fatalf("create web: status = %d: %s", status, resp.Error)
+	}
+	m, _ := ts.s.Get("web")
+	createdAt := m.CreatedAt
+
+	expectExpiry := func(t *testing.T, expiresAt time.Time, lease string) {
+		t.Helper()
+		m, ok := ts.s.Get("web")
+		if !ok {
+			t.Fatal("no metadata for web")
+		}
+		if expiresAt.IsZero() != (m.ExpiresAt == nil) || (m.ExpiresAt != nil && !m.ExpiresAt.Equal(expiresAt)) {
+			t.Fatalf("expires_at = %v, want %v", m.ExpiresAt, expiresAt)
+		}
+		if m.Lease != lease {
+			t.Fatalf("lease = %q, want %q", m.Lease, lease)
+		}
+	}
+	withExpiry := func(expiry string) string {
+		return strings.TrimSuffix(webTunnel, "}") + "," + expiry + "}"
+	}
+
+	t.Run("apply ttl", func(t *testing.T) {
+		body := `{"tunnels":[` + withExpiry(`"ttl":"2h"`) + `]}`
+		if status, resp := ts.do("POST", "/api/apply", body); status != http.StatusOK {
+			t.Fatalf("status = %d: %s", status, resp.Error)
+		}
+		expectExpiry(t, createdAt.Add(2*time.Hour), "")
+
+		// the ttl counts from creation, so applying it again changes nothing
+		status, resp := ts.do("POST", "/api/apply", body)
+		if status != http.StatusOK || resp.Message != "0 created, 0 updated, 0 deleted, 1 unchanged" {
+			t.Fatalf("reapply: status = %d: %s%s", status, resp.Message, resp.Error)
+		}
+	})
+
+	t.Run("apply without expiry", func(t *testing.T) {
+		if status, resp := ts.do("POST", "/api/apply", `{"tunnels":[`+webTunnel+`]}`); status != http.StatusOK {
+			t.Fatalf("status = %d: %s", status, resp.Error)
+		}
+		expectExpiry(t, time.Time{}, "")
+	})
+
+	t.Run("batch lease", func(t *testing.T) {
+		body := `{"operations":[{"action":"update","name":"web","tunnel":` + withExpiry(`"lease":"1m"`) + `}]}`
+		before := time.Now()
+		if status, resp := ts.do("POST", "/api/tunnels/batch", body); status != http.StatusOK {
+			t.Fatalf("status = %d: %s", status, resp.Error)
+		}
+		m, _ := ts.s.Get("web")
+		if m.Lease != "1m" || m.ExpiresAt == nil || m.ExpiresAt.Before(before.Add(time.Minute)) {
+			t.Fatalf("expires_at = %v, lease = %q, want a 1m lease", m.ExpiresAt, m.Lease)
+		}
+	})
+
+	t.Run("patch ttl", func(t *testing.T) {
+		if status, resp := ts.do("PATCH", "/api/tunnel/web", `{"ttl":"30m"}`); status != http.StatusOK {
+			t.Fatalf("status = %d: %s", status, resp.Error)
+		}
+		expectExpiry(t, createdAt.Add(30*time.Minute), "")
+	})
+
+	t.Run("patch labels keeps expiry", func(t *testing.T) {
+		if status, resp := ts.do("PATCH", "/api/tunnel/web", `{"labels":{"env":"dev"}}`); status != http.StatusOK {
+			t.Fatalf("status = %d: %s", status, resp.Error)
+		}
+		expectExpiry(t, createdAt.Add(30*time.Minute), "")
+	})
+
+	t.Run("patch invalid ttl", func(t *testing.T) {
+		if status, _ := ts.do("PATCH", "/api/tunnel/web", `{"ttl":"-1h"}`); status != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", status, http.StatusBadRequest)
+		}
+	})
+}
+
+// TestUpdateMetadataSaveFailureRollsBack makes sure PATCH undoes the config
+// change when the metadata can't be saved.
+func TestUpdateMetadataSaveFailureRollsBack(t *testing.T) {
+	ts := newTestServer(t)
+	if status, resp := ts.do("POST", "/api/tunnel", webTunnel); status != http.StatusOK {
+		t.Fatalf("create web: status = %d: %s", status, resp.Error)
+	}
+	before, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.failStateSaves()
+	if status, resp := ts.do("PATCH", "/api/tunnel/web", `{"local_port":9090,"ttl":"1h"}`); status != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d: %s", status, http.StatusInternalServerError, resp.Error)
+	}
+
+	after, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Fatalf("tunnel was not restored: %+v", after.Proxy)
+	}
+	if m, _ := ts.s.Get("web"); m.ExpiresAt != nil {
+		t.Fatalf("expires_at = %v, want none", m.ExpiresAt)
+	}
+}
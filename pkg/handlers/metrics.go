@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/cbodonnell/tfarm/pkg/stats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tfarm_api_requests_total",
+		Help: "API requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tfarm_api_request_duration_seconds",
+		Help:    "API request latency by route and method. Event streams are not observed.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
+
+// NewMetricsHandler serves tfarmd metrics in the Prometheus text format.
+// Tunnel and frpc metrics are collected on each scrape. rec is nil when
+// traffic stats are not sampled, in which case no traffic metrics are
+// exported.
+func NewMetricsHandler(b backend.Backend, s *state.Store, rec *stats.Recorder) http.Handler {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		apiRequests,
+		apiRequestDuration,
+		&frpcCollector{b: b},
+		&tunnelCollector{b: b, s: s},
+	)
+	if rec != nil {
+		r.MustRegister(&trafficCollector{rec: rec})
+	}
+	return promhttp.HandlerFor(r, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
+
+var (
+	frpcUpDesc = prometheus.NewDesc("tfarm_frpc_up",
+		"Whether the frpc process is running.", nil, nil)
+	frpcUptimeDesc = prometheus.NewDesc("tfarm_frpc_uptime_seconds",
+		"Seconds since the frpc process was started.", nil, nil)
+	frpcDegradedDesc = prometheus.NewDesc("tfarm_frpc_degraded",
+		"Whether frpc is crash looping.", nil, nil)
+	frpcRestartsDesc = prometheus.NewDesc("tfarm_frpc_restarts_total",
+		"Times the frpc process was started again after the first start.", nil, nil)
+	frpcLastLoginDesc = prometheus.NewDesc("tfarm_frpc_last_login_timestamp_seconds",
+		"Unix time of the last successful login to frps, 0 if none.", nil, nil)
+	frpcLastDisconnectDesc = prometheus.NewDesc("tfarm_frpc_last_disconnect_timestamp_seconds",
+		"Unix time frpc last lost its connection to frps, 0 if never.", nil, nil)
+	frpcCommandFailuresDesc = prometheus.NewDesc("tfarm_frpc_command_failures_total",
+		"Failed frpc commands by subcommand.", []string{"command"}, nil)
+)
+
+// frpcCollector reports the state of the frpc process.
+type frpcCollector struct {
+	b backend.Backend
+}
+
+func (c *frpcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- frpcUpDesc
+	ch <- frpcUptimeDesc
+	ch <- frpcDegradedDesc
+	ch <- frpcRestartsDesc
+	ch <- frpcLastLoginDesc
+	ch <- frpcLastDisconnectDesc
+	ch <- frpcCommandFailuresDesc
+}
+
+func (c *frpcCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.b.Stats()
+
+	up, uptime := 0.0, 0.0
+	if !stats.StartedAt.IsZero() {
+		up = 1
+		uptime = time.Since(stats.StartedAt).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(frpcUpDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(frpcUptimeDesc, prometheus.GaugeValue, uptime)
+
+	degraded := 0.0
+	if c.b.Supervisor().Degraded {
+		degraded = 1
+	}
+	ch <- prometheus.MustNewConstMetric(frpcDegradedDesc, prometheus.GaugeValue, degraded)
+
+	restarts := 0
+	if stats.Starts > 0 {
+		restarts = stats.Starts - 1
+	}
+	ch <- prometheus.MustNewConstMetric(frpcRestartsDesc, prometheus.CounterValue, float64(restarts))
+
+	ch <- prometheus.MustNewConstMetric(frpcLastLoginDesc, prometheus.GaugeValue, unixSeconds(stats.LastLogin))
+	ch <- prometheus.MustNewConstMetric(frpcLastDisconnectDesc, prometheus.GaugeValue, unixSeconds(stats.LastDisconnect))
+
+	for _, cmd := range []string{"verify", "reload"} {
+		ch <- prometheus.MustNewConstMetric(frpcCommandFailuresDesc, prometheus.CounterValue,
+			float64(stats.CommandFailures[cmd]), cmd)
+	}
+}
+
+// unixSeconds returns t as fractional unix seconds, or 0 if t is zero.
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+var (
+	tunnelUpDesc = prometheus.NewDesc("tfarm_tunnel_up",
+		"Whether the tunnel's proxy is running, by tunnel.", []string{"name", "type", "status"}, nil)
+	tunnelsDesc = prometheus.NewDesc("tfarm_tunnels",
+		"Number of tunnels by type and status.", []string{"type", "status"}, nil)
+)
+
+// tunnelCollector reports the configured tunnels and the status of their
+// proxies.
+type tunnelCollector struct {
+	b backend.Backend
+	s *state.Store
+}
+
+func (c *tunnelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tunnelUpDesc
+	ch <- tunnelsDesc
+}
+
+func (c *tunnelCollector) Collect(ch chan<- prometheus.Metric) {
+	if !c.b.Running() {
+		// the status can't be read without frpc
+		return
+	}
+
+	c.b.RLock()
+	tunnels, err := listTunnels(c.b, c.s)
+	c.b.RUnlock()
+	if err != nil {
+		slog.Error("failed to collect tunnel metrics", "err", err)
+		return
+	}
+
+	type key struct{ tunnelType, status string }
+	counts := make(map[key]int)
+	for _, t := range tunnels {
+		counts[key{t.Type, t.Status}]++
+		up := 0.0
+		if t.Status == "running" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(tunnelUpDesc, prometheus.GaugeValue, up, t.Name, t.Type, t.Status)
+	}
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(tunnelsDesc, prometheus.GaugeValue, float64(n), k.tunnelType, k.status)
+	}
+}
+
+var (
+	tunnelTrafficInDesc = prometheus.NewDesc("tfarm_tunnel_traffic_in_bytes_total",
+		"Bytes received by the tunnel's proxy on frps. frps counts traffic per day, so this resets at midnight server time.",
+		[]string{"name"}, nil)
+	tunnelTrafficOutDesc = prometheus.NewDesc("tfarm_tunnel_traffic_out_bytes_total",
+		"Bytes sent by the tunnel's proxy on frps. frps counts traffic per day, so this resets at midnight server time.",
+		[]string{"name"}, nil)
+	tunnelConnsDesc = prometheus.NewDesc("tfarm_tunnel_connections",
+		"Open connections to the tunnel's proxy on frps.", []string{"name"}, nil)
+)
+
+// trafficCollector reports the latest traffic sample of each tunnel, as
+// sampled from the frps dashboard.
+type trafficCollector struct {
+	rec *stats.Recorder
+}
+
+func (c *trafficCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tunnelTrafficInDesc
+	ch <- tunnelTrafficOutDesc
+	ch <- tunnelConnsDesc
+}
+
+func (c *trafficCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, sample := range c.rec.Latest() {
+		ch <- prometheus.MustNewConstMetric(tunnelTrafficInDesc, prometheus.CounterValue, float64(sample.TrafficIn), name)
+		ch <- prometheus.MustNewConstMetric(tunnelTrafficOutDesc, prometheus.CounterValue, float64(sample.TrafficOut), name)
+		ch <- prometheus.MustNewConstMetric(tunnelConnsDesc, prometheus.GaugeValue, float64(sample.Conns), name)
+	}
+}
+
+// instrumentMiddleware counts API requests and observes their latency by
+// route template.
+func instrumentMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		apiRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		if rec.Header().Get("Content-Type") != "text/event-stream" {
+			apiRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}
+	})
+}
+
+// statusRecorder records the response status code. It passes flushes
+// through so event streams still work.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/stats"
+)
+
+// scrape returns the metrics served by h in the Prometheus text format.
+func scrape(t *testing.T, h http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	b, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	if status, resp := ts.do("POST", "/api/tunnel", webTunnel); status != http.StatusOK {
+		t.Fatalf("create: %d %+v", status, resp)
+	}
+	ts.b.Disconnect()
+
+	rec := stats.NewRecorder(2)
+	rec.Record("web", api.TrafficSample{Time: time.Now(), TrafficIn: 10, TrafficOut: 20, Conns: 1})
+	rec.Record("web", api.TrafficSample{Time: time.Now(), TrafficIn: 100, TrafficOut: 200, Conns: 3})
+	rec.Record("web", api.TrafficSample{Time: time.Now(), TrafficIn: 1000, TrafficOut: 2000, Conns: 2})
+
+	body := scrape(t, NewMetricsHandler(ts.b, ts.s, rec))
+	for _, want := range []string{
+		"tfarm_frpc_up 1",
+		"tfarm_frpc_degraded 0",
+		"tfarm_frpc_restarts_total 0",
+		`tfarm_frpc_command_failures_total{command="verify"} 0`,
+		`tfarm_tunnel_up{name="web",status="running",type="http"} 1`,
+		`tfarm_tunnels{status="running",type="http"} 1`,
+		`tfarm_tunnel_traffic_in_bytes_total{name="web"} 1000`,
+		`tfarm_tunnel_traffic_out_bytes_total{name="web"} 2000`,
+		`tfarm_tunnel_connections{name="web"} 2`,
+		`tfarm_api_requests_total{code="200",method="POST",route="/api/tunnel"}`,
+		`tfarm_api_request_duration_seconds_count{method="POST",route="/api/tunnel"}`,
+		"# TYPE tfarm_tunnel_traffic_in_bytes_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+	if strings.Contains(body, "tfarm_frpc_last_disconnect_timestamp_seconds 0\n") {
+		t.Error("last disconnect was not reported")
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
+
+func TestMetricsWithoutTrafficStats(t *testing.T) {
+	ts := newTestServer(t)
+	if err := ts.b.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	body := scrape(t, NewMetricsHandler(ts.b, ts.s, nil))
+	if !strings.Contains(body, "tfarm_frpc_up 0") {
+		t.Errorf("metrics are missing tfarm_frpc_up 0:\n%s", body)
+	}
+	for _, unwanted := range []string{"tfarm_tunnel_traffic", "tfarm_tunnel_up"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("metrics include %s:\n%s", unwanted, body)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+)
+
+// StartReaper deletes expired tunnels every interval. Expiry times are
+// kept in the state store, so tunnels that expired while tfarmd was not
+// running are deleted on the first pass. The returned func stops it.
+func StartReaper(b backend.Backend, s *state.Store, interval time.Duration) (stop func()) {
+	return startLoop(interval, func() {
+		reapExpired(b, s)
+	})
+}
+
+func reapExpired(b backend.Backend, s *state.Store) {
+	expired := s.Expired(time.Now())
+	if len(expired) == 0 {
+		return
+	}
+
+	// deleting reloads frpc, so wait until it is running
+	if !b.Running() {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	for _, name := range expired {
+		m, ok := s.Get(name)
+		if !ok || m.ExpiresAt == nil || m.ExpiresAt.After(time.Now()) {
+			// changed while waiting for the lock
+			continue
+		}
+
+		if err := deleteTunnel(b, s, name); err != nil {
+			if os.IsNotExist(err) {
+				deleteMetadata(s, name)
+				continue
+			}
+			slog.Error("failed to delete expired tunnel", "tunnel", name, "err", err)
+			continue
+		}
+		slog.Info("deleted expired tunnel", "tunnel", name, "expired_at", *m.ExpiresAt)
+	}
+}
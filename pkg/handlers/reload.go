@@ -1,17 +1,17 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
-	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/backend"
 )
 
-func HandleReload(f *frpc.Frpc) func(w http.ResponseWriter, r *http.Request) {
+func HandleReload(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if _, err := f.Output("reload"); err != nil {
-			log.Printf("failed to reload: %s", err)
+		if err := b.Reload(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to reload", "err", err)
 			api.RespondWithError(w, http.StatusInternalServerError, "failed to reload")
 			return
 		}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/gorilla/mux"
+)
+
+// HandleRenew extends the lease of a tunnel. Clients holding a lease
+// renew it periodically, and the reaper deletes the tunnel once they stop.
+func HandleRenew(s *state.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		tunnelName := vars["name"]
+
+		m, ok := s.Get(tunnelName)
+		if !ok {
+			slog.WarnContext(r.Context(), "tunnel does not exist", "tunnel", tunnelName)
+			api.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("tunnel does not exist: %s", tunnelName))
+			return
+		}
+
+		lease, err := time.ParseDuration(m.Lease)
+		if err != nil {
+			slog.WarnContext(r.Context(), "tunnel has no lease", "tunnel", tunnelName)
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("tunnel has no lease: %s", tunnelName))
+			return
+		}
+
+		expiresAt := time.Now().UTC().Add(lease)
+		m.ExpiresAt = &expiresAt
+		if err := s.Put(tunnelName, m); err != nil {
+			slog.ErrorContext(r.Context(), "failed to save metadata", "tunnel", tunnelName, "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to renew lease")
+			return
+		}
+
+		api.RespondWithData(w, fmt.Sprintf("lease renewed until %s", expiresAt.Format(time.RFC3339)), &api.RenewResponse{ExpiresAt: expiresAt})
+	}
+}
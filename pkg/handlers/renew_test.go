@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expire moves the expiry of the named tunnel to the past, as if its
+// holder had stopped renewing it.
+func (ts *testServer) expire(name string) {
+	ts.t.Helper()
+	m, ok := ts.s.Get(name)
+	if !ok {
+		ts.t.Fatalf("no metadata for %s", name)
+	}
+	expiresAt := time.Now().UTC().Add(-time.Second)
+	m.ExpiresAt = &expiresAt
+	if err := ts.s.Put(name, m); err != nil {
+		ts.t.Fatal(err)
+	}
+}
+
+// TestRenewBeforeReaping makes sure renewing a tunnel whose lease ran out
+// keeps the reaper from deleting it, while the tunnels that weren't
+// renewed are deleted.
+func TestRenewBeforeReaping(t *testing.T) {
+	ts := newTestServer(t)
+	withLease := func(tunnel string) string {
+		return strings.TrimSuffix(tunnel, "}") + `,"lease":"1m"}`
+	}
+	for _, tunnel := range []string{withLease(webTunnel), withLease(dbTunnel)} {
+		if status, resp := ts.do("POST", "/api/tunnel", tunnel); status != http.StatusOK {
+			t.Fatalf("create: status = %d: %s", status, resp.Error)
+		}
+	}
+	ts.expire("web")
+	ts.expire("db")
+
+	before := time.Now()
+	status, resp := ts.do("POST", "/api/tunnel/web/renew", "")
+	if status != http.StatusOK || !strings.HasPrefix(resp.Message, "lease renewed until ") {
+		t.Fatalf("status = %d: %s%s", status, resp.Message, resp.Error)
+	}
+	m, _ := ts.s.Get("web")
+	if m.ExpiresAt == nil || m.ExpiresAt.Before(before.Add(time.Minute)) {
+		t.Fatalf("expires_at = %v, want a minute from now", m.ExpiresAt)
+	}
+
+	reapExpired(ts.b, ts.s)
+
+	if _, err := ts.b.Tunnel("web"); err != nil {
+		t.Fatalf("the renewed tunnel was deleted: %s", err)
+	}
+	if _, ok := ts.s.Get("web"); !ok {
+		t.Fatal("the metadata of the renewed tunnel was deleted")
+	}
+	if _, err := ts.b.Tunnel("db"); !os.IsNotExist(err) {
+		t.Fatalf("the expired tunnel was not deleted: %v", err)
+	}
+	if _, ok := ts.s.Get("db"); ok {
+		t.Fatal("the metadata of the expired tunnel was not deleted")
+	}
+}
+
+func TestRenewRejected(t *testing.T) {
+	ts := newTestServer(t)
+	if status, resp := ts.do("POST", "/api/tunnel", strings.TrimSuffix(webTunnel, "}")+`,"ttl":"1h"}`); status != http.StatusOK {
+		t.Fatalf("create web: status = %d: %s", status, resp.Error)
+	}
+	m, _ := ts.s.Get("web")
+
+	tests := []struct {
+		name    string
+		path    string
+		code    int
+		message string
+	}{
+		{name: "unknown tunnel", path: "/api/tunnel/db/renew", code: http.StatusNotFound, message: "tunnel does not exist: db"},
+		{name: "no lease", path: "/api/tunnel/web/renew", code: http.StatusBadRequest, message: "tunnel has no lease: web"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := ts.do("POST", tt.path, "")
+			if status != tt.code || resp.Error != tt.message {
+				t.Fatalf("status = %d, error = %q, want %d %q", status, resp.Error, tt.code, tt.message)
+			}
+		})
+	}
+
+	if after, _ := ts.s.Get("web"); !after.ExpiresAt.Equal(*m.ExpiresAt) {
+		t.Fatalf("expires_at = %v, want the ttl's %v", after.ExpiresAt, m.ExpiresAt)
+	}
+}
@@ -1,17 +1,17 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
-	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/backend"
 )
 
-func HandleRestart(f *frpc.Frpc) func(w http.ResponseWriter, r *http.Request) {
+func HandleRestart(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := f.Restart(); err != nil {
-			log.Printf("failed to restart: %s", err)
+		if err := b.Restart(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to restart", "err", err)
 			api.RespondWithError(w, http.StatusInternalServerError, "failed to restart")
 			return
 		}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/stats"
+	"github.com/gorilla/mux"
+)
+
+const statsDisabledMessage = "traffic stats are not enabled, start tfarmd with --frps-dashboard-addr"
+
+// StartStatsSampler records the traffic of every tunnel from the frps
+// dashboard every interval. user is the frpc user that frps prefixes the
+// proxy names with. The returned func stops it.
+func StartStatsSampler(b backend.Backend, user string, d *stats.Dashboard, rec *stats.Recorder, interval time.Duration) (stop func()) {
+	return startLoop(interval, func() {
+		if !b.Running() {
+			return
+		}
+		if err := sampleStats(b, user, d, rec); err != nil {
+			slog.Error("failed to sample traffic stats", "err", err)
+		}
+	})
+}
+
+func sampleStats(b backend.Backend, user string, d *stats.Dashboard, rec *stats.Recorder) error {
+	b.RLock()
+	tunnels, err := b.Tunnels()
+	b.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// visitors don't register a proxy on frps
+	names := make(map[string]bool)
+	types := make(map[string]bool)
+	for name, t := range tunnels {
+		if t.IsVisitor() {
+			continue
+		}
+		names[name] = true
+		types[t.Proxy.Type] = true
+	}
+	rec.Prune(names)
+
+	// frps knows proxies by their name prefixed with the frpc user, if set
+	prefix := ""
+	if user != "" {
+		prefix = user + "."
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	for proxyType := range types {
+		proxies, err := d.ProxyStats(ctx, proxyType)
+		if err != nil {
+			return fmt.Errorf("failed to get %s proxy stats: %s", proxyType, err)
+		}
+		for _, ps := range proxies {
+			name, ok := strings.CutPrefix(ps.Name, prefix)
+			if !ok || !names[name] {
+				// another client's proxy
+				continue
+			}
+			rec.Record(name, api.TrafficSample{
+				Time:       now,
+				TrafficIn:  ps.TodayTrafficIn,
+				TrafficOut: ps.TodayTrafficOut,
+				Conns:      ps.CurConns,
+			})
+		}
+	}
+
+	return nil
+}
+
+func HandleStats(rec *stats.Recorder) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rec == nil {
+			api.RespondWithError(w, http.StatusServiceUnavailable, statsDisabledMessage)
+			return
+		}
+
+		api.RespondWithData(w, "", &api.StatsResponse{Tunnels: rec.All()})
+	}
+}
+
+func HandleTunnelStats(b backend.Backend, rec *stats.Recorder) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		tunnelName := vars["name"]
+
+		if rec == nil {
+			api.RespondWithError(w, http.StatusServiceUnavailable, statsDisabledMessage)
+			return
+		}
+
+		if _, err := b.Tunnel(tunnelName); err != nil {
+			slog.WarnContext(r.Context(), "tunnel does not exist", "tunnel", tunnelName)
+			api.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("tunnel does not exist: %s", tunnelName))
+			return
+		}
+
+		// the tunnel has no samples until the next tick after it was created
+		samples, _ := rec.Samples(tunnelName)
+		if samples == nil {
+			samples = []api.TrafficSample{}
+		}
+
+		api.RespondWithData(w, "", &api.TunnelStats{Name: tunnelName, Samples: samples})
+	}
+}
@@ -1,21 +1,59 @@
 package handlers
 
 import (
-	"log"
+	"bytes"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"sort"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
-	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/rodaine/table"
 )
 
-func HandleStatus(f *frpc.Frpc) func(w http.ResponseWriter, r *http.Request) {
+func HandleStatus(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		output, err := f.Status()
+		statuses, err := b.Status()
 		if err != nil {
-			log.Printf("failed to get frpc status: %s", err)
+			slog.ErrorContext(r.Context(), "failed to get frpc status", "err", err)
 			api.RespondWithError(w, http.StatusInternalServerError, "failed to get frpc status")
 			return
 		}
-		api.RespondWithSuccess(w, string(output))
+
+		status := &api.StatusResponse{
+			Proxies: make([]api.ProxyStatus, 0, len(statuses)),
+		}
+		for _, ps := range statuses {
+			status.Proxies = append(status.Proxies, ps)
+		}
+		sort.Slice(status.Proxies, func(i, j int) bool {
+			return status.Proxies[i].Name < status.Proxies[j].Name
+		})
+
+		// the message carries the rendered table for clients that predate the data field
+		api.RespondWithData(w, string(statusTable(status.Proxies)), status)
 	}
 }
+
+// statusTable renders the proxy statuses as a table, like frpc status.
+func statusTable(proxies []api.ProxyStatus) []byte {
+	buf := new(bytes.Buffer)
+	tbl := table.New("Name", "Type", "Status", "Local", "Remote", "Error").WithWriter(buf)
+
+	for _, ps := range proxies {
+		if ps.Type == "http" || ps.Type == "https" {
+			if ps.LocalAddr != "" {
+				ps.LocalAddr = fmt.Sprintf("%s://%s", ps.Type, ps.LocalAddr)
+			}
+			if ps.RemoteAddr != "" {
+				ps.RemoteAddr = fmt.Sprintf("%s://%s", ps.Type, ps.RemoteAddr)
+			}
+		}
+		tbl.AddRow(ps.Name, ps.Type, ps.Status, ps.LocalAddr, ps.RemoteAddr, ps.Error)
+	}
+
+	tbl.Print()
+
+	return buf.Bytes()
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+)
+
+// listTunnels reads all tunnels from the backend and merges them with
+// their stored metadata and the proxy status reported by the backend.
+// If the backend can't report the statuses, they are all unknown.
+func listTunnels(b backend.Backend, s *state.Store) ([]api.Tunnel, error) {
+	confs, err := b.Tunnels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tunnel configs: %s", err)
+	}
+
+	statuses, err := proxyStatuses(b)
+	if err != nil {
+		// the configs are still worth listing while frpc is down
+		slog.Warn("failed to get tunnel status, listing tunnels without it", "err", err)
+	}
+
+	tunnels := make([]api.Tunnel, 0, len(confs))
+	for name, conf := range confs {
+		tunnel := newTunnel(name, conf, statuses)
+		if m, ok := s.Get(name); ok {
+			setTunnelMetadata(&tunnel, m)
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Name < tunnels[j].Name
+	})
+
+	return tunnels, nil
+}
+
+// proxyStatuses returns the proxy statuses keyed by proxy name.
+func proxyStatuses(b backend.Backend) (map[string]api.ProxyStatus, error) {
+	statuses, err := b.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get frpc status: %s", err)
+	}
+	return statuses, nil
+}
+
+// previousTunnels returns the changes that undo changes made to tunnels.
+func previousTunnels(tunnels map[string]*backend.Tunnel, changes backend.TunnelChanges) backend.TunnelChanges {
+	previous := make(backend.TunnelChanges, len(changes))
+	for name := range changes {
+		// a tunnel that didn't exist is deleted
+		previous[name] = tunnels[name]
+	}
+	return previous
+}
+
+// applyTunnels applies the changes through the backend and returns the
+// message to respond with if it fails.
+func applyTunnels(b backend.Backend, changes backend.TunnelChanges) (string, error) {
+	err := b.ApplyTunnels(changes)
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.Is(err, backend.ErrVerify):
+		return "failed to verify", err
+	case errors.Is(err, backend.ErrReload):
+		return "failed to reload", err
+	default:
+		return "failed to apply tunnel configs", err
+	}
+}
+
+func newTunnel(name string, t *backend.Tunnel, statuses map[string]api.ProxyStatus) api.Tunnel {
+	if t.IsVisitor() {
+		return api.Tunnel{
+			Name:       name,
+			Type:       t.Visitor.Type,
+			Role:       "visitor",
+			ServerName: t.Visitor.ServerName,
+			ServerUser: t.Visitor.ServerUser,
+			BindAddr:   t.Visitor.BindAddr,
+			BindPort:   t.Visitor.BindPort,
+		}
+	}
+
+	req := t.Proxy
+	tunnel := api.Tunnel{
+		Name:       name,
+		Type:       req.Type,
+		LocalIP:    req.LocalIP,
+		LocalPort:  req.LocalPort,
+		RemotePort: req.RemotePort,
+		ProxyID:    req.ProxyID,
+		Status:     "unknown",
+
+		BandwidthLimit:      req.BandwidthLimit,
+		UseEncryption:       req.UseEncryption,
+		UseCompression:      req.UseCompression,
+		HealthCheckType:     req.HealthCheckType,
+		HealthCheckURL:      req.HealthCheckURL,
+		HealthCheckInterval: req.HealthCheckInterval,
+		Group:               req.Group,
+
+		CustomDomains:     req.CustomDomains,
+		Locations:         req.Locations,
+		HostHeaderRewrite: req.HostHeaderRewrite,
+		HTTPUser:          req.HTTPUser,
+		Headers:           req.Headers,
+
+		AllowUsers: req.AllowUsers,
+	}
+
+	if req.Plugin != nil {
+		tunnel.Plugin = &api.Plugin{Name: req.Plugin.Name}
+		for k, v := range req.Plugin.Params {
+			if k == "passwd" || k == "http_passwd" {
+				continue
+			}
+			if tunnel.Plugin.Params == nil {
+				tunnel.Plugin.Params = make(map[string]string)
+			}
+			tunnel.Plugin.Params[k] = v
+		}
+	}
+
+	if ps, ok := statuses[name]; ok {
+		tunnel.Status = ps.Status
+		tunnel.Error = ps.Error
+		if ps.RemoteAddr != "" {
+			tunnel.RemoteURL = fmt.Sprintf("%s://%s", tunnel.Type, ps.RemoteAddr)
+		}
+	}
+
+	return tunnel
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+	"github.com/gorilla/mux"
+)
+
+func HandleUpdate(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		tunnelName := vars["name"]
+
+		var updateRequest api.UpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+			slog.ErrorContext(r.Context(), "failed to decode request body", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, "failed to decode request body")
+			return
+		}
+
+		// kept to undo the update if its metadata can't be saved
+		original, err := b.Tunnel(tunnelName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				slog.WarnContext(r.Context(), "tunnel does not exist", "tunnel", tunnelName)
+				api.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("tunnel does not exist: %s", tunnelName))
+				return
+			}
+			slog.ErrorContext(r.Context(), "failed to read tunnel config", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to read tunnel config")
+			return
+		}
+		if original.IsVisitor() {
+			slog.WarnContext(r.Context(), "cannot update visitor", "tunnel", tunnelName)
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("cannot update visitor: %s", tunnelName))
+			return
+		}
+
+		// start from the current config so the proxy id is preserved
+		updated := *original.Proxy
+		createRequest := &updated
+		if updateRequest.LocalIP != "" {
+			createRequest.LocalIP = updateRequest.LocalIP
+		}
+		if updateRequest.LocalPort != 0 {
+			createRequest.LocalPort = updateRequest.LocalPort
+		}
+		if updateRequest.RemotePort != 0 {
+			createRequest.RemotePort = updateRequest.RemotePort
+		}
+
+		if updateRequest.Labels != nil {
+			if err := validateLabels(*updateRequest.Labels); err != nil {
+				slog.WarnContext(r.Context(), "invalid update request", "err", err)
+				api.RespondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		expiry := &api.CreateRequest{TTL: updateRequest.TTL, ExpiresAt: updateRequest.ExpiresAt, Lease: updateRequest.Lease}
+		if err := validateExpiry(expiry); err != nil {
+			slog.WarnContext(r.Context(), "invalid update request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := validateCreateRequest(createRequest); err != nil {
+			slog.WarnContext(r.Context(), "invalid update request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if message, err := applyTunnels(b, backend.TunnelChanges{tunnelName: {Proxy: createRequest}}); err != nil {
+			slog.ErrorContext(r.Context(), message, "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, message)
+			return
+		}
+
+		err = updateMetadata(s, tunnelName, func(m *state.Metadata, now time.Time) {
+			if updateRequest.Labels != nil {
+				m.Labels = *updateRequest.Labels
+				if len(m.Labels) == 0 {
+					m.Labels = nil
+				}
+			}
+			if updateRequest.Description != "" {
+				m.Description = updateRequest.Description
+			}
+			if expiry.TTL != "" || expiry.ExpiresAt != nil || expiry.Lease != "" {
+				setExpiry(m, expiry, now)
+			}
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to save metadata", "tunnel", tunnelName, "err", err)
+			revertTunnels(b, backend.TunnelChanges{tunnelName: original})
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to save tunnel metadata, the tunnel was not updated")
+			return
+		}
+		publishTunnelEvents(b, api.EventTunnelUpdated, tunnelName)
+
+		api.RespondWithSuccess(w, "tunnel updated")
+	}
+}
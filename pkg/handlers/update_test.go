@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cbodonnell/tfarm/pkg/backend"
+)
+
+// labeledWebTunnel is webTunnel with labels, a description and a ttl.
+var labeledWebTunnel = strings.TrimSuffix(webTunnel, "}") + `,"labels":{"env":"dev","team":"web"},"description":"my site","ttl":"2h"}`
+
+// TestUpdateKeepsIdentityAndMetadata makes sure PATCH changes only what it
+// is given, and keeps the proxy id, labels and expiry of the tunnel.
+func TestUpdateKeepsIdentityAndMetadata(t *testing.T) {
+	ts := newTestServer(t)
+	if status, resp := ts.do("POST", "/api/tunnel", labeledWebTunnel); status != http.StatusOK {
+		t.Fatalf("create web: status = %d: %s", status, resp.Error)
+	}
+	before, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := ts.s.Get("web")
+
+	if status, resp := ts.do("PATCH", "/api/tunnel/web", `{"local_port":9090}`); status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, resp.Error)
+	}
+
+	after, err := ts.b.Tunnel("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Proxy.LocalPort != 9090 {
+		t.Fatalf("local_port = %d, want 9090", after.Proxy.LocalPort)
+	}
+	if after.Proxy.ProxyID == "" || after.Proxy.ProxyID != before.Proxy.ProxyID {
+		t.Fatalf("proxy id = %q, want %q", after.Proxy.ProxyID, before.Proxy.ProxyID)
+	}
+	updated, _ := ts.s.Get("web")
+	if !reflect.DeepEqual(updated.Labels, m.Labels) || updated.Description != m.Description {
+		t.Fatalf("labels = %v, description = %q, want %v %q", updated.Labels, updated.Description, m.Labels, m.Description)
+	}
+	if updated.ExpiresAt == nil || !updated.ExpiresAt.Equal(*m.ExpiresAt) {
+		t.Fatalf("expires_at = %v, want %v", updated.ExpiresAt, m.ExpiresAt)
+	}
+	if !updated.UpdatedAt.After(m.UpdatedAt) {
+		t.Fatalf("updated_at = %v, want after %v", updated.UpdatedAt, m.UpdatedAt)
+	}
+}
+
+func TestUpdateLabels(t *testing.T) {
+	ts := newTestServer(t)
+	if status, resp := ts.do("POST", "/api/tunnel", labeledWebTunnel); status != http.StatusOK {
+		t.Fatalf("create web: status = %d: %s", status, resp.Error)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		labels map[string]string
+	}{
+		{name: "unchanged", body: `{"description":"still my site"}`, labels: map[string]string{"env": "dev", "team": "web"}},
+		{name: "replaced", body: `{"labels":{"env":"prod"}}`, labels: map[string]string{"env": "prod"}},
+		{name: "cleared", body: `{"labels":{}}`, labels: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, resp := ts.do("PATCH", "/api/tunnel/web", tt.body); status != http.StatusOK {
+				t.Fatalf("status = %d: %s", status, resp.Error)
+			}
+			if m, _ := ts.s.Get("web"); !reflect.DeepEqual(m.Labels, tt.labels) {
+				t.Fatalf("labels = %v, want %v", m.Labels, tt.labels)
+			}
+		})
+	}
+}
+
+// TestUpdateFailedChangesRollBack makes sure a PATCH the backend rejects,
+// or fails to reload, changes neither the tunnel nor its metadata.
+func TestUpdateFailedChangesRollBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    func(b *backend.Fake, err error)
+		message string
+	}{
+		{name: "verify", fail: (*backend.Fake).FailVerify, message: "failed to verify"},
+		{name: "reload", fail: (*backend.Fake).FailReload, message: "failed to reload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if status, resp := ts.do("POST", "/api/tunnel", labeledWebTunnel); status != http.StatusOK {
+				t.Fatalf("create web: status = %d: %s", status, resp.Error)
+			}
+			tunnels, metadata := ts.snapshot()
+
+			tt.fail(ts.b, errors.New("rejected"))
+			body := `{"local_port":9090,"labels":{"env":"prod"},"description":"moved","lease":"1m"}`
+			status, resp := ts.do("PATCH", "/api/tunnel/web", body)
+			if status != http.StatusInternalServerError || resp.Error != tt.message {
+				t.Fatalf("status = %d, error = %q, want %d %q", status, resp.Error, http.StatusInternalServerError, tt.message)
+			}
+
+			after, m := ts.snapshot()
+			if !reflect.DeepEqual(after, tunnels) {
+				t.Fatalf("web was changed to %+v", after["web"].Proxy)
+			}
+			if !reflect.DeepEqual(m, metadata) {
+				t.Fatalf("the metadata of web was changed to %+v", m["web"])
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+)
+
+var (
+	// tunnel names are used as file names in conf.d and as ini section names
+	tunnelNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	domainRegexp     = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	headerNameRegexp = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+)
+
+func validateTunnelName(name string) error {
+	if !tunnelNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid tunnel name: %q", name)
+	}
+	return nil
+}
+
+// validateIniValues makes sure none of the values can break out of the
+// line they are rendered on in a tunnel config file.
+func validateIniValues(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid value: %q", v)
+		}
+	}
+	return nil
+}
+
+func validatePort(field string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%s must be between 1 and 65535", field)
+	}
+	return nil
+}
+
+func validateCreateRequest(req *api.CreateRequest) error {
+	if err := validateTunnelName(req.Name); err != nil {
+		return err
+	}
+
+	switch req.Type {
+	case "http", "https", "tcp", "udp", "stcp", "xtcp", "sudp":
+	default:
+		return fmt.Errorf("invalid tunnel type: %s", req.Type)
+	}
+
+	if err := validateIniValues(req.LocalIP, req.SecretKey, req.HostHeaderRewrite, req.HTTPUser, req.HTTPPwd); err != nil {
+		return err
+	}
+
+	if req.Plugin != nil {
+		if err := validatePlugin(req.Type, req.Plugin); err != nil {
+			return err
+		}
+		if req.LocalIP != "" || req.LocalPort != 0 {
+			return errors.New("local_ip and local_port are not valid for plugin tunnels")
+		}
+	} else if err := validatePort("local_port", req.LocalPort); err != nil {
+		return err
+	}
+
+	if req.Type == "tcp" || req.Type == "udp" {
+		if err := validatePort("remote_port", req.RemotePort); err != nil {
+			return err
+		}
+	} else if req.RemotePort != 0 {
+		return errors.New("remote_port is only valid for tcp and udp tunnels")
+	}
+
+	if isSecretTunnelType(req.Type) {
+		if req.SecretKey == "" {
+			return fmt.Errorf("sk is required for %s tunnels", req.Type)
+		}
+		for _, user := range req.AllowUsers {
+			if user == "" || strings.ContainsAny(user, ", \r\n") {
+				return fmt.Errorf("invalid allow_users entry: %q", user)
+			}
+		}
+	} else if req.SecretKey != "" || len(req.AllowUsers) > 0 {
+		return errors.New("sk and allow_users are only valid for stcp, xtcp and sudp tunnels")
+	}
+
+	if err := validateTrafficOptions(req); err != nil {
+		return err
+	}
+
+	if err := validateLabels(req.Labels); err != nil {
+		return err
+	}
+
+	if err := validateExpiry(req); err != nil {
+		return err
+	}
+
+	return validateHTTPOptions(req)
+}
+
+// validBandwidthLimit reports whether limit is a number of KB or MB.
+func validBandwidthLimit(limit string) bool {
+	n, ok := strings.CutSuffix(limit, "KB")
+	if !ok {
+		n, ok = strings.CutSuffix(limit, "MB")
+	}
+	if !ok {
+		return false
+	}
+	_, err := strconv.ParseFloat(n, 64)
+	return err == nil
+}
+
+func validateTrafficOptions(req *api.CreateRequest) error {
+	if err := validateIniValues(req.BandwidthLimit, req.HealthCheckURL, req.Group, req.GroupKey); err != nil {
+		return err
+	}
+
+	if req.BandwidthLimit != "" {
+		if !validBandwidthLimit(req.BandwidthLimit) {
+			return fmt.Errorf("invalid bandwidth_limit: %q, use a number of KB or MB such as 512KB or 1MB", req.BandwidthLimit)
+		}
+	}
+
+	switch req.HealthCheckType {
+	case "":
+		if req.HealthCheckURL != "" || req.HealthCheckInterval != 0 {
+			return errors.New("health_check_url and health_check_interval_s require a health_check_type")
+		}
+	case "tcp", "http":
+		if req.Plugin != nil {
+			return errors.New("health checks are not supported for plugin tunnels")
+		}
+		if req.HealthCheckType == "http" && !strings.HasPrefix(req.HealthCheckURL, "/") {
+			return fmt.Errorf("invalid health_check_url: %q, http health checks require a path starting with /", req.HealthCheckURL)
+		}
+		if req.HealthCheckType == "tcp" && req.HealthCheckURL != "" {
+			return errors.New("health_check_url is only valid for http health checks")
+		}
+		if req.HealthCheckInterval < 0 {
+			return errors.New("health_check_interval_s must not be negative")
+		}
+	default:
+		return fmt.Errorf("invalid health_check_type: %s", req.HealthCheckType)
+	}
+
+	if req.Group != "" && req.Type != "tcp" && req.Type != "http" {
+		return errors.New("group is only valid for tcp and http tunnels")
+	}
+	if req.Group == "" && req.GroupKey != "" {
+		return errors.New("group_key requires a group")
+	}
+
+	return nil
+}
+
+// pluginSpec lists the parameters a client plugin accepts and the tunnel
+// types it can serve.
+type pluginSpec struct {
+	types    []string
+	required []string
+	optional []string
+}
+
+var pluginSpecs = map[string]pluginSpec{
+	"static_file": {
+		types:    []string{"tcp", "http", "https", "stcp", "xtcp"},
+		required: []string{"local_path"},
+		optional: []string{"strip_prefix", "http_user", "http_passwd"},
+	},
+	"unix_domain_socket": {
+		types:    []string{"tcp", "http", "https", "stcp", "xtcp"},
+		required: []string{"unix_path"},
+	},
+	"http_proxy": {
+		types:    []string{"tcp", "stcp", "xtcp"},
+		optional: []string{"http_user", "http_passwd"},
+	},
+	"socks5": {
+		types:    []string{"tcp", "stcp", "xtcp"},
+		optional: []string{"user", "passwd"},
+	},
+	"https2http": {
+		types:    []string{"https"},
+		required: []string{"local_addr"},
+		optional: []string{"crt_path", "key_path", "host_header_rewrite"},
+	},
+	"https2https": {
+		types:    []string{"https"},
+		required: []string{"local_addr"},
+		optional: []string{"crt_path", "key_path", "host_header_rewrite"},
+	},
+	"http2https": {
+		types:    []string{"http"},
+		required: []string{"local_addr"},
+		optional: []string{"host_header_rewrite"},
+	},
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePlugin(tunnelType string, plugin *api.Plugin) error {
+	spec, ok := pluginSpecs[plugin.Name]
+	if !ok {
+		return fmt.Errorf("invalid plugin: %q", plugin.Name)
+	}
+
+	if !contains(spec.types, tunnelType) {
+		return fmt.Errorf("plugin %s is only valid for %s tunnels", plugin.Name, strings.Join(spec.types, ", "))
+	}
+
+	for _, name := range spec.required {
+		if plugin.Params[name] == "" {
+			return fmt.Errorf("plugin %s requires param %s", plugin.Name, name)
+		}
+	}
+
+	for name, value := range plugin.Params {
+		if !contains(spec.required, name) && !contains(spec.optional, name) {
+			return fmt.Errorf("invalid param for plugin %s: %q", plugin.Name, name)
+		}
+		if err := validateIniValues(value); err != nil {
+			return fmt.Errorf("invalid value for plugin param %s: %q", name, value)
+		}
+	}
+
+	if (plugin.Params["http_user"] == "") != (plugin.Params["http_passwd"] == "") {
+		return errors.New("plugin params http_user and http_passwd must be set together")
+	}
+	if (plugin.Params["user"] == "") != (plugin.Params["passwd"] == "") {
+		return errors.New("plugin params user and passwd must be set together")
+	}
+
+	return nil
+}
+
+func validateHTTPOptions(req *api.CreateRequest) error {
+	if req.Type != "http" && req.Type != "https" && len(req.CustomDomains) > 0 {
+		return errors.New("custom_domains is only valid for http and https tunnels")
+	}
+
+	// https tunnels take custom domains too, and they are rendered into the
+	// config all the same
+	for _, domain := range req.CustomDomains {
+		if !domainRegexp.MatchString(domain) {
+			return fmt.Errorf("invalid custom domain: %q", domain)
+		}
+	}
+
+	if req.Type != "http" {
+		if len(req.Locations) > 0 || req.HostHeaderRewrite != "" || req.HTTPUser != "" || req.HTTPPwd != "" || len(req.Headers) > 0 {
+			return errors.New("locations, host_header_rewrite, http_user, http_pwd and headers are only valid for http tunnels")
+		}
+		return nil
+	}
+
+	for _, location := range req.Locations {
+		if !strings.HasPrefix(location, "/") || strings.ContainsAny(location, ", \r\n") {
+			return fmt.Errorf("invalid location: %q, locations must start with /", location)
+		}
+	}
+
+	if (req.HTTPUser == "") != (req.HTTPPwd == "") {
+		return errors.New("http_user and http_pwd must be set together")
+	}
+
+	for name, value := range req.Headers {
+		if !headerNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid header name: %q", name)
+		}
+		if err := validateIniValues(value); err != nil {
+			return fmt.Errorf("invalid value for header %s: %q", name, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestCreateRejectsCustomDomainInjection(t *testing.T) {
+	for _, tunnelType := range []string{"http", "https"} {
+		t.Run(tunnelType, func(t *testing.T) {
+			ts := newTestServer(t)
+
+			body := fmt.Sprintf(`{"name":"web","type":%q,"local_ip":"127.0.0.1","local_port":8080,"custom_domains":["example.com\nplugin = unix_domain_socket"]}`, tunnelType)
+			status, resp := ts.do("POST", "/api/tunnel", body)
+			if status != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", status, http.StatusBadRequest, resp.Error)
+			}
+
+			if _, err := ts.b.Tunnel("web"); !os.IsNotExist(err) {
+				t.Fatalf("tunnel was created: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateAcceptsCustomDomains(t *testing.T) {
+	for _, tunnelType := range []string{"http", "https"} {
+		t.Run(tunnelType, func(t *testing.T) {
+			ts := newTestServer(t)
+
+			body := fmt.Sprintf(`{"name":"web","type":%q,"local_ip":"127.0.0.1","local_port":8080,"custom_domains":["example.com","*.example.org"]}`, tunnelType)
+			if status, resp := ts.do("POST", "/api/tunnel", body); status != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", status, http.StatusOK, resp.Error)
+			}
+		})
+	}
+}
+
+func TestValidBandwidthLimit(t *testing.T) {
+	tests := map[string]bool{
+		"512KB": true,
+		"1MB":   true,
+		"1.5MB": true,
+		"1GB":   false,
+		"MB":    false,
+		"1 MB":  false,
+		"512":   false,
+	}
+	for limit, want := range tests {
+		if got := validBandwidthLimit(limit); got != want {
+			t.Errorf("validBandwidthLimit(%q) = %t, want %t", limit, got, want)
+		}
+	}
+}
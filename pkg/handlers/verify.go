@@ -1,20 +1,26 @@
 package handlers
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/cbodonnell/tfarm/pkg/api"
-	"github.com/cbodonnell/tfarm/pkg/frpc"
+	"github.com/cbodonnell/tfarm/pkg/backend"
 )
 
-func HandleVerify(f *frpc.Frpc) func(w http.ResponseWriter, r *http.Request) {
+func HandleVerify(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if _, err := f.Output("verify"); err != nil {
-			log.Printf("failed to verify: %s", err)
-			api.RespondWithError(w, http.StatusInternalServerError, "failed to verify")
+		output, err := b.Verify()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to verify", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, fmt.Sprintf("failed to verify: %s", strings.TrimSpace(string(output))))
 			return
 		}
-		api.RespondWithSuccess(w, "frpc configuration verified")
+		result := &api.VerifyResponse{
+			Output: strings.TrimSpace(string(output)),
+		}
+		api.RespondWithData(w, "frpc configuration verified", result)
 	}
 }
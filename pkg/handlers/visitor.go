@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/cbodonnell/tfarm/pkg/state"
+)
+
+func HandleCreateVisitor(b backend.Backend, s *state.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var visitorRequest api.VisitorRequest
+		if err := json.NewDecoder(r.Body).Decode(&visitorRequest); err != nil {
+			slog.ErrorContext(r.Context(), "failed to decode request body", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, "failed to decode request body")
+			return
+		}
+
+		if err := validateTunnelName(visitorRequest.Name); err != nil {
+			slog.WarnContext(r.Context(), "invalid visitor request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !isSecretTunnelType(visitorRequest.Type) {
+			slog.WarnContext(r.Context(), "invalid visitor type", "type", visitorRequest.Type)
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid visitor type: %s", visitorRequest.Type))
+			return
+		}
+
+		if visitorRequest.ServerName == "" || visitorRequest.SecretKey == "" || visitorRequest.BindPort == 0 {
+			slog.WarnContext(r.Context(), "server_name, sk, and bind_port are required")
+			api.RespondWithError(w, http.StatusBadRequest, "server_name, sk, and bind_port are required")
+			return
+		}
+
+		if err := validatePort("bind_port", visitorRequest.BindPort); err != nil {
+			slog.WarnContext(r.Context(), "invalid visitor request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if visitorRequest.BindAddr == "" {
+			visitorRequest.BindAddr = "127.0.0.1"
+		}
+
+		if err := validateLabels(visitorRequest.Labels); err != nil {
+			slog.WarnContext(r.Context(), "invalid visitor request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := validateIniValues(visitorRequest.ServerName, visitorRequest.ServerUser, visitorRequest.SecretKey, visitorRequest.BindAddr); err != nil {
+			slog.WarnContext(r.Context(), "invalid visitor request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if _, err := b.Tunnel(visitorRequest.Name); err == nil {
+			slog.WarnContext(r.Context(), "tunnel already exists", "tunnel", visitorRequest.Name)
+			api.RespondWithError(w, http.StatusConflict, fmt.Sprintf("tunnel already exists: %s", visitorRequest.Name))
+			return
+		} else if !os.IsNotExist(err) {
+			slog.ErrorContext(r.Context(), "failed to read tunnel config", "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to read tunnel config")
+			return
+		}
+
+		if message, err := applyTunnels(b, backend.TunnelChanges{visitorRequest.Name: {Visitor: &visitorRequest}}); err != nil {
+			slog.ErrorContext(r.Context(), message, "err", err)
+			api.RespondWithError(w, http.StatusInternalServerError, message)
+			return
+		}
+
+		if err := createMetadata(s, visitorRequest.Name, state.Metadata{
+			Creator:     requestCreator(r),
+			Labels:      visitorRequest.Labels,
+			Description: visitorRequest.Description,
+		}); err != nil {
+			slog.ErrorContext(r.Context(), "failed to save metadata", "tunnel", visitorRequest.Name, "err", err)
+			revertTunnels(b, backend.TunnelChanges{visitorRequest.Name: nil})
+			api.RespondWithError(w, http.StatusInternalServerError, "failed to save tunnel metadata, the visitor was not created")
+			return
+		}
+		publishTunnelEvents(b, api.EventTunnelCreated, visitorRequest.Name)
+
+		api.RespondWithSuccess(w, "visitor created")
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCreateVisitorBindPort(t *testing.T) {
+	tests := []struct {
+		port   int
+		status int
+	}{
+		{port: -1, status: http.StatusBadRequest},
+		{port: 70000, status: http.StatusBadRequest},
+		{port: 65536, status: http.StatusBadRequest},
+		{port: 1, status: http.StatusOK},
+		{port: 65535, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.port), func(t *testing.T) {
+			ts := newTestServer(t)
+
+			body := fmt.Sprintf(`{"name":"v","type":"stcp","server_name":"db","sk":"secret","bind_port":%d}`, tt.port)
+			if status, resp := ts.do("POST", "/api/visitor", body); status != tt.status {
+				t.Fatalf("status = %d, want %d: %s", status, tt.status, resp.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+	"github.com/cbodonnell/tfarm/pkg/backend"
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxWaitTimeout   = 10 * time.Minute
+	waitPollInterval = 500 * time.Millisecond
+)
+
+// waitStatuses are the statuses frpc reports for a proxy.
+var waitStatuses = []string{"new", "wait start", "start error", "running", "check failed", "closed"}
+
+// errWaitTimeout is returned when the proxy did not reach the status in time.
+var errWaitTimeout = errors.New("timed out")
+
+// HandleWait blocks until the tunnel's proxy reaches the status given by
+// ?for= (running by default) or reports an error, or until ?timeout= runs out.
+func HandleWait(b backend.Backend) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		tunnelName := vars["name"]
+
+		b.RLock()
+		_, err := b.Tunnel(tunnelName)
+		b.RUnlock()
+		if err != nil {
+			slog.WarnContext(r.Context(), "tunnel does not exist", "tunnel", tunnelName)
+			api.RespondWithError(w, http.StatusNotFound, fmt.Sprintf("tunnel does not exist: %s", tunnelName))
+			return
+		}
+
+		waitFor, timeout, err := parseWaitParams(r)
+		if err != nil {
+			slog.WarnContext(r.Context(), "invalid wait request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respondWithWait(w, r, b, tunnelName, waitFor, timeout, fmt.Sprintf("tunnel %s", waitFor))
+	}
+}
+
+// withWait lets ?wait=true make a create request wait until the new
+// tunnel is running. The wait happens after next returns, so the config
+// lock next runs under isn't held while waiting.
+func withWait(b backend.Backend, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") != "true" {
+			next(w, r)
+			return
+		}
+
+		_, timeout, err := parseWaitParams(r)
+		if err != nil {
+			slog.WarnContext(r.Context(), "invalid wait request", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// next consumes the body, so keep a copy to read the tunnel name from
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to read request body", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next(buf, r)
+		if buf.status != http.StatusOK {
+			w.WriteHeader(buf.status)
+			w.Write(buf.body.Bytes())
+			return
+		}
+
+		var createRequest api.CreateRequest
+		if err := json.Unmarshal(body, &createRequest); err != nil {
+			// next decoded the same body, so this can't happen
+			slog.ErrorContext(r.Context(), "failed to decode request body", "err", err)
+			api.RespondWithError(w, http.StatusBadRequest, "failed to decode request body")
+			return
+		}
+
+		respondWithWait(w, r, b, createRequest.Name, "running", timeout, "tunnel created and running")
+	}
+}
+
+// bufferedResponse holds a response so it can be replaced.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func parseWaitParams(r *http.Request) (string, time.Duration, error) {
+	query := r.URL.Query()
+
+	waitFor := query.Get("for")
+	if waitFor == "" {
+		waitFor = "running"
+	}
+	if !contains(waitStatuses, waitFor) {
+		return "", 0, fmt.Errorf("invalid status to wait for: %q", waitFor)
+	}
+
+	timeout := api.DefaultWaitTimeout
+	if t := query.Get("timeout"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil || timeout <= 0 || timeout > maxWaitTimeout {
+			return "", 0, fmt.Errorf("invalid timeout: %q, must be a positive duration of at most %s", t, maxWaitTimeout)
+		}
+	}
+
+	return waitFor, timeout, nil
+}
+
+func respondWithWait(w http.ResponseWriter, r *http.Request, b backend.Backend, tunnelName, waitFor string, timeout time.Duration, message string) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	ps, err := waitForProxy(ctx, b, tunnelName, waitFor)
+	if err != nil {
+		slog.Warn("failed waiting for tunnel", "tunnel", tunnelName, "status", waitFor, "err", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, errWaitTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		message := fmt.Sprintf("tunnel %s is not %s: %s", tunnelName, waitFor, err)
+		if ps == nil {
+			api.RespondWithError(w, status, message)
+			return
+		}
+		api.RespondWithErrorData(w, status, message, ps)
+		return
+	}
+
+	api.RespondWithData(w, message, ps)
+}
+
+// waitForProxy polls the backend until the named proxy has the status,
+// reports an error, or ctx is done. The last status seen is
+// returned either way, if the proxy was found at all.
+func waitForProxy(ctx context.Context, b backend.Backend, name, waitFor string) (*api.ProxyStatus, error) {
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+
+	var last *api.ProxyStatus
+	for {
+		b.RLock()
+		statuses, err := proxyStatuses(b)
+		b.RUnlock()
+		// frpc may be reloading, so errors are retried until the timeout
+		if ps, ok := statuses[name]; err == nil && ok {
+			last = &ps
+		}
+
+		if last != nil {
+			if last.Status == waitFor {
+				return last, nil
+			}
+			if last.Error != "" {
+				return last, errors.New(last.Error)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if last == nil {
+				return nil, fmt.Errorf("%w, proxy not found in frpc status", errWaitTimeout)
+			}
+			return last, fmt.Errorf("%w with status %s", errWaitTimeout, last.Status)
+		case <-ticker.C:
+		}
+	}
+}
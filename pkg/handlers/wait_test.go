@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+)
+
+var (
+	startError = api.ProxyStatus{Type: "http", Status: "start error", Error: "port already used"}
+	waitStart  = api.ProxyStatus{Type: "http", Status: "wait start"}
+)
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		status  *api.ProxyStatus
+		code    int
+		message string
+	}{
+		{name: "running", path: "/api/tunnel/web/wait", code: http.StatusOK, message: "tunnel running"},
+		{name: "for status", path: "/api/tunnel/web/wait?for=wait+start", status: &waitStart, code: http.StatusOK, message: "tunnel wait start"},
+		{name: "error", path: "/api/tunnel/web/wait", status: &startError, code: http.StatusBadGateway, message: "tunnel web is not running: port already used"},
+		{name: "timeout", path: "/api/tunnel/web/wait?timeout=100ms", status: &waitStart, code: http.StatusGatewayTimeout, message: "tunnel web is not running: timed out with status wait start"},
+		{name: "unknown tunnel", path: "/api/tunnel/db/wait", code: http.StatusNotFound, message: "tunnel does not exist: db"},
+		{name: "invalid status", path: "/api/tunnel/web/wait?for=up", code: http.StatusBadRequest, message: `invalid status to wait for: "up"`},
+		{name: "invalid timeout", path: "/api/tunnel/web/wait?timeout=1h", code: http.StatusBadRequest, message: `invalid timeout: "1h", must be a positive duration of at most 10m0s`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if status, resp := ts.do("POST", "/api/tunnel", webTunnel); status != http.StatusOK {
+				t.Fatalf("create web: status = %d: %s", status, resp.Error)
+			}
+			if tt.status != nil {
+				ts.b.SetStatus("web", *tt.status)
+			}
+
+			status, resp := ts.do("GET", tt.path, "")
+			if status != tt.code || resp.Message+resp.Error != tt.message {
+				t.Fatalf("status = %d, message = %q, want %d %q", status, resp.Message+resp.Error, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+// TestCreateAndWait makes sure ?wait=true answers a create with the status
+// the new tunnel reaches, or with the create's own error.
+func TestCreateAndWait(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		status     *api.ProxyStatus
+		failVerify bool
+		code       int
+		message    string
+	}{
+		{name: "running", query: "?wait=true", code: http.StatusOK, message: "tunnel created and running"},
+		{name: "error", query: "?wait=true", status: &startError, code: http.StatusBadGateway, message: "tunnel web is not running: port already used"},
+		{name: "timeout", query: "?wait=true&timeout=100ms", status: &waitStart, code: http.StatusGatewayTimeout, message: "tunnel web is not running: timed out with status wait start"},
+		{name: "create fails", query: "?wait=true", failVerify: true, code: http.StatusInternalServerError, message: "failed to verify"},
+		{name: "invalid timeout", query: "?wait=true&timeout=soon", code: http.StatusBadRequest, message: `invalid timeout: "soon", must be a positive duration of at most 10m0s`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			// the status is reported once the tunnel exists
+			if tt.status != nil {
+				ts.b.SetStatus("web", *tt.status)
+			}
+			if tt.failVerify {
+				ts.b.FailVerify(errors.New("rejected"))
+			}
+
+			status, resp := ts.do("POST", "/api/tunnel"+tt.query, webTunnel)
+			if status != tt.code || resp.Message+resp.Error != tt.message {
+				t.Fatalf("status = %d, message = %q, want %d %q", status, resp.Message+resp.Error, tt.code, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+var (
+	// frpcLineRegexp matches frpc's log lines, such as
+	// 2023/08/01 12:00:00 [I] [proxy_manager.go:144] [a1b2c3d4] [web] start proxy success
+	// where the bracketed run id and proxy name are each optional.
+	frpcLineRegexp = regexp.MustCompile(`^\S+ \S+ \[([TDIWE])\] \[([^\]]+)\] (?:\[([^\]]*)\] )?(?:\[([^\]]*)\] )?(.*)$`)
+	ansiRegexp     = regexp.MustCompile("\x1b\\[[0-9;]*m")
+)
+
+var frpcLevels = map[string]slog.Level{
+	"T": slog.LevelDebug,
+	"D": slog.LevelDebug,
+	"I": slog.LevelInfo,
+	"W": slog.LevelWarn,
+	"E": slog.LevelError,
+}
+
+// FrpcLine is a parsed frpc log line.
+type FrpcLine struct {
+	Level   slog.Level
+	Caller  string // frp source file and line
+	RunID   string
+	Proxy   string
+	Message string
+}
+
+// ParseFrpcLine parses a frpc log line. Lines that aren't in frp's log
+// format, such as startup errors, are not parsed.
+func ParseFrpcLine(line string) (FrpcLine, bool) {
+	m := frpcLineRegexp.FindStringSubmatch(ansiRegexp.ReplaceAllString(line, ""))
+	if m == nil {
+		return FrpcLine{}, false
+	}
+	return FrpcLine{
+		Level:   frpcLevels[m[1]],
+		Caller:  m[2],
+		RunID:   m[3],
+		Proxy:   m[4],
+		Message: m[5],
+	}, true
+}
+
+// LogFrpcOutput logs each line read from one of frpc's output streams,
+// with the level and fields frpc logged it with. fn, if not nil, is
+// called with each raw line.
+func LogFrpcOutput(r io.Reader, stream string, fn func(line string)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fn != nil {
+			fn(line)
+		}
+		LogFrpcLine(line, stream)
+	}
+}
+
+// LogFrpcLine logs a line of frpc output. Lines frpc didn't log through
+// its logger are logged as is, at warn level if they went to stderr.
+func LogFrpcLine(line, stream string) {
+	parsed, ok := ParseFrpcLine(line)
+	if !ok {
+		level := slog.LevelInfo
+		if stream == "stderr" {
+			level = slog.LevelWarn
+		}
+		slog.Log(context.Background(), level, strings.TrimSpace(ansiRegexp.ReplaceAllString(line, "")), "component", "frpc", "stream", stream)
+		return
+	}
+
+	args := []any{"component", "frpc", "caller", parsed.Caller}
+	if parsed.RunID != "" {
+		args = append(args, "run_id", parsed.RunID)
+	}
+	if parsed.Proxy != "" {
+		args = append(args, "proxy", parsed.Proxy)
+	}
+	slog.Log(context.Background(), parsed.Level, parsed.Message, args...)
+}
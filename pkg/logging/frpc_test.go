@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestParseFrpcLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want FrpcLine
+		ok   bool
+	}{
+		{
+			name: "run id and proxy",
+			line: "2023/08/01 12:00:00 [I] [proxy_manager.go:144] [a1b2c3d4] [web] start proxy success",
+			want: FrpcLine{Level: slog.LevelInfo, Caller: "proxy_manager.go:144", RunID: "a1b2c3d4", Proxy: "web", Message: "start proxy success"},
+			ok:   true,
+		},
+		{
+			name: "run id only",
+			line: "2023/08/01 12:00:00 [I] [service.go:287] [a1b2c3d4] login to server success, get run id [a1b2c3d4]",
+			want: FrpcLine{Level: slog.LevelInfo, Caller: "service.go:287", RunID: "a1b2c3d4", Message: "login to server success, get run id [a1b2c3d4]"},
+			ok:   true,
+		},
+		{
+			name: "no run id",
+			line: "2023/08/01 12:00:00 [W] [service.go:133] login to server failed: dial tcp: connection refused",
+			want: FrpcLine{Level: slog.LevelWarn, Caller: "service.go:133", Message: "login to server failed: dial tcp: connection refused"},
+			ok:   true,
+		},
+		{
+			name: "colored",
+			line: "\x1b[1;34m2023/08/01 12:00:00 [I] [proxy_manager.go:144] [a1b2c3d4] [web] start proxy success\x1b[0m",
+			want: FrpcLine{Level: slog.LevelInfo, Caller: "proxy_manager.go:144", RunID: "a1b2c3d4", Proxy: "web", Message: "start proxy success"},
+			ok:   true,
+		},
+		{
+			name: "trace",
+			line: "2023/08/01 12:00:00 [T] [control.go:200] [a1b2c3d4] heartbeat",
+			want: FrpcLine{Level: slog.LevelDebug, Caller: "control.go:200", RunID: "a1b2c3d4", Message: "heartbeat"},
+			ok:   true,
+		},
+		{
+			name: "debug",
+			line: "2023/08/01 12:00:00 [D] [control.go:170] [a1b2c3d4] send heartbeat to server",
+			want: FrpcLine{Level: slog.LevelDebug, Caller: "control.go:170", RunID: "a1b2c3d4", Message: "send heartbeat to server"},
+			ok:   true,
+		},
+		{
+			name: "error",
+			line: "2023/08/01 12:00:00 [E] [proxy_wrapper.go:204] [a1b2c3d4] [web] start error: port already used",
+			want: FrpcLine{Level: slog.LevelError, Caller: "proxy_wrapper.go:204", RunID: "a1b2c3d4", Proxy: "web", Message: "start error: port already used"},
+			ok:   true,
+		},
+		{name: "plain output", line: "frpc version 0.51.3", ok: false},
+		{name: "unknown level", line: "2023/08/01 12:00:00 [X] [service.go:133] login to server failed", ok: false},
+		{name: "no caller", line: "2023/08/01 12:00:00 [I] start proxy success", ok: false},
+		{name: "empty", line: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseFrpcLine(tt.line)
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("ParseFrpcLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
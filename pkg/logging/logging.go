@@ -1,14 +1,78 @@
+// Package logging sets up tfarmd's structured logging.
 package logging
 
 import (
-	"bufio"
+	"context"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"strings"
 )
 
-func LogReaderWithPrefix(r io.Reader, prefix string) {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		log.Printf("%s%s", prefix, scanner.Text())
+// New returns a logger writing to w at the given level (debug, info, warn
+// or error, default info) in the given format (text or json, default text).
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
 	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format: %s, must be text or json", format)
+	}
+
+	return slog.New(&contextHandler{h}), nil
+}
+
+// ParseLevel parses a log level. frp's level names are accepted too, so
+// the same value can be used for tfarmd and frpc.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level: %s, must be debug, info, warn or error", level)
+}
+
+type attrsKey struct{}
+
+// WithAttrs returns a context whose log records carry the given attributes,
+// as key-value pairs or slog.Attrs. Log with the slog *Context functions to
+// include them.
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	attrs, _ := ctx.Value(attrsKey{}).([]any)
+	return context.WithValue(ctx, attrsKey{}, append(attrs[:len(attrs):len(attrs)], args...))
+}
+
+// contextHandler adds the attributes set with WithAttrs to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]any); ok {
+		r.Add(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
 }
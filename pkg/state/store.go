@@ -0,0 +1,194 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metadata is what tfarmd records about a tunnel beyond its frpc config.
+type Metadata struct {
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Creator     string            `json:"creator,omitempty"` // common name of the client certificate
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"` // the tunnel is deleted after this time
+	Lease       string            `json:"lease,omitempty"`      // renewing moves expires_at to now plus the lease
+}
+
+// Store persists tunnel metadata in a JSON file keyed by tunnel name.
+// Changes are written through to the file on every call.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	tunnels map[string]Metadata
+}
+
+// Open loads the store at path, or starts an empty one if the file does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		tunnels: make(map[string]Metadata),
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read state file: %s", err)
+	}
+
+	if err := json.Unmarshal(b, &s.tunnels); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %s", path, err)
+	}
+
+	return s, nil
+}
+
+// Get returns the metadata of the named tunnel.
+func (s *Store) Get(name string) (Metadata, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.tunnels[name]
+	return m, ok
+}
+
+// All returns a copy of the metadata of all tunnels.
+func (s *Store) All() map[string]Metadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make(map[string]Metadata, len(s.tunnels))
+	for name, m := range s.tunnels {
+		all[name] = m
+	}
+	return all
+}
+
+// Put sets the metadata of the named tunnel.
+func (s *Store) Put(name string, m Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.tunnels[name]
+	s.tunnels[name] = m
+	if err := s.save(); err != nil {
+		if ok {
+			s.tunnels[name] = previous
+		} else {
+			delete(s.tunnels, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the metadata of the named tunnels.
+func (s *Store) Delete(names ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := make(map[string]Metadata)
+	for _, name := range names {
+		if m, ok := s.tunnels[name]; ok {
+			removed[name] = m
+			delete(s.tunnels, name)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		for name, m := range removed {
+			s.tunnels[name] = m
+		}
+		return err
+	}
+	return nil
+}
+
+// Update sets the metadata in put and removes the metadata of the tunnels
+// in remove as one: if saving fails, none of the changes are kept.
+func (s *Store) Update(put map[string]Metadata, remove []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := make(map[string]Metadata, len(s.tunnels))
+	for name, m := range s.tunnels {
+		previous[name] = m
+	}
+	for name, m := range put {
+		s.tunnels[name] = m
+	}
+	for _, name := range remove {
+		delete(s.tunnels, name)
+	}
+	if err := s.save(); err != nil {
+		s.tunnels = previous
+		return err
+	}
+	return nil
+}
+
+// Expired returns the names of tunnels that expired before now.
+func (s *Store) Expired(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name, m := range s.tunnels {
+		if m.ExpiresAt != nil && m.ExpiresAt.Before(now) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Prune removes the metadata of tunnels that are not in names, e.g.
+// because their config was removed while tfarmd was not running.
+func (s *Store) Prune(names []string) error {
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+
+	var stale []string
+	for name := range s.All() {
+		if !keep[name] {
+			stale = append(stale, name)
+		}
+	}
+
+	return s.Delete(stale...)
+}
+
+// save writes the store to a temp file and renames it into place so the
+// file is never left half written.
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.tunnels, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %s", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %s", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %s", err)
+	}
+
+	return nil
+}
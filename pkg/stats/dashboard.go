@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Dashboard is a client for the frps dashboard api, the only place frp
+// reports traffic and connection counts.
+type Dashboard struct {
+	addr     string // e.g. http://frps.example.com:7500
+	user     string
+	password string
+
+	httpClient *http.Client
+}
+
+// ProxyStats is a proxy as reported by the frps dashboard.
+type ProxyStats struct {
+	Name            string `json:"name"`
+	TodayTrafficIn  int64  `json:"today_traffic_in"`
+	TodayTrafficOut int64  `json:"today_traffic_out"`
+	CurConns        int64  `json:"cur_conns"`
+	Status          string `json:"status"`
+}
+
+func NewDashboard(addr, user, password string) *Dashboard {
+	return &Dashboard{
+		addr:       strings.TrimSuffix(addr, "/"),
+		user:       user,
+		password:   password,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ProxyStats returns the stats of all proxies of the given type known to frps.
+func (d *Dashboard) ProxyStats(ctx context.Context, proxyType string) ([]ProxyStats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/proxy/%s", d.addr, proxyType), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request: %s", err)
+	}
+	if d.user != "" || d.password != "" {
+		req.SetBasicAuth(d.user, d.password)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send http request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http request failed with status code: %s", resp.Status)
+	}
+
+	var res struct {
+		Proxies []ProxyStats `json:"proxies"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("parse http response error: %s", err)
+	}
+
+	return res.Proxies, nil
+}
@@ -0,0 +1,105 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cbodonnell/tfarm/pkg/api"
+)
+
+// Recorder keeps the most recent traffic samples of each tunnel in a ring
+// buffer, so memory use doesn't grow with uptime.
+type Recorder struct {
+	size int
+
+	mu      sync.Mutex
+	tunnels map[string]*ring
+}
+
+type ring struct {
+	samples []api.TrafficSample
+	next    int // index the next sample is written to
+	full    bool
+}
+
+// NewRecorder keeps up to size samples per tunnel.
+func NewRecorder(size int) *Recorder {
+	return &Recorder{
+		size:    size,
+		tunnels: make(map[string]*ring),
+	}
+}
+
+func (r *Recorder) Record(name string, sample api.TrafficSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rb, ok := r.tunnels[name]
+	if !ok {
+		rb = &ring{samples: make([]api.TrafficSample, r.size)}
+		r.tunnels[name] = rb
+	}
+	rb.samples[rb.next] = sample
+	rb.next = (rb.next + 1) % r.size
+	if rb.next == 0 {
+		rb.full = true
+	}
+}
+
+// Samples returns the samples of the named tunnel, oldest first.
+func (r *Recorder) Samples(name string) ([]api.TrafficSample, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rb, ok := r.tunnels[name]
+	if !ok {
+		return nil, false
+	}
+	if !rb.full {
+		return append([]api.TrafficSample(nil), rb.samples[:rb.next]...), true
+	}
+	return append(append([]api.TrafficSample(nil), rb.samples[rb.next:]...), rb.samples[:rb.next]...), true
+}
+
+// All returns the samples of all tunnels, sorted by name.
+func (r *Recorder) All() []api.TunnelStats {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.tunnels))
+	for name := range r.tunnels {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	all := make([]api.TunnelStats, 0, len(names))
+	for _, name := range names {
+		if samples, ok := r.Samples(name); ok {
+			all = append(all, api.TunnelStats{Name: name, Samples: samples})
+		}
+	}
+	return all
+}
+
+// Prune drops the samples of tunnels that are not in names.
+func (r *Recorder) Prune(names map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.tunnels {
+		if !names[name] {
+			delete(r.tunnels, name)
+		}
+	}
+}
+
+// Latest returns the most recent sample of each tunnel.
+func (r *Recorder) Latest() map[string]api.TrafficSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	latest := make(map[string]api.TrafficSample, len(r.tunnels))
+	for name, rb := range r.tunnels {
+		last := (rb.next - 1 + r.size) % r.size
+		latest[name] = rb.samples[last]
+	}
+	return latest
+}
@@ -10,6 +10,7 @@ import (
 	"syscall"
 
 	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
 )
 
 // StringPrompt asks for a string value using the label
@@ -49,3 +50,16 @@ func PrettyJSON(v any) ([]byte, error) {
 	}
 	return out.Bytes(), nil
 }
+
+func PrettyYAML(v any) ([]byte, error) {
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("error marshaling to yaml: %s", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("error marshaling to yaml: %s", err)
+	}
+	return out.Bytes(), nil
+}